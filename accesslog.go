@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+type AccessLogConfig struct {
+	// Format is "text", "json" or "off", by default that of the log
+	Format string `json:"format"`
+}
+
+// Validate implements the common.Validator interface.
+func (c AccessLogConfig) Validate() error {
+	switch c.Format {
+	case "", "text", "json", "off":
+		return nil
+	}
+	return &common.ConfigError{Path: "format", Err: errors.New(`must be "text", "json" or "off"`)}
+}
+
+// clientIPResolver finds the address of the client behind trusted reverse
+// proxies.
+type clientIPResolver struct {
+	trusted []netip.Prefix
+	header  string // "" if the peer is the client
+}
+
+func newClientIPResolver(config HTTPConfig) (*clientIPResolver, error) {
+	r := &clientIPResolver{header: http.CanonicalHeaderKey(config.ClientIPHeader)}
+	trusted := config.TrustedProxies
+	if trusted == nil {
+		trusted = defaultTrustedProxies
+	}
+	if err := common.CheckPrefixes("trusted-proxies", trusted); err != nil {
+		return nil, err
+	}
+	for _, s := range trusted {
+		prefix, _ := common.ParsePrefix(s)
+		r.trusted = append(r.trusted, prefix)
+	}
+	return r, nil
+}
+
+func (r *clientIPResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of req. The configured header
+// is only believed if the peer is a trusted proxy, or connected through a
+// Unix domain socket, and the peer is the client if the header is missing or
+// invalid.
+func (r *clientIPResolver) ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		host = addr.Unmap().String()
+		if !r.isTrusted(addr) {
+			return host
+		}
+	}
+	if r.header == "" {
+		return host
+	}
+
+	values := req.Header.Values(r.header)
+	if len(values) == 0 {
+		return host
+	}
+	if r.header != "X-Forwarded-For" {
+		// A proxy that adds the header rather than replacing it puts its
+		// own last
+		if addr, err := netip.ParseAddr(strings.TrimSpace(values[len(values)-1])); err == nil {
+			return addr.Unmap().String()
+		}
+		return host
+	}
+	// Each proxy appends the address it got the request from, so the client
+	// is the rightmost address not belonging to a trusted proxy.
+	hops := strings.Split(strings.Join(values, ","), ",")
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+	if client != "" {
+		return client
+	}
+	return host
+}
+
+// requestID returns the X-Request-ID of r if it is reasonable, or else a new
+// random ID.
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	valid := id != "" && len(id) <= 128
+	for i := 0; valid && i < len(id); i++ {
+		valid = id[i] > ' ' && id[i] < 0x7f && id[i] != '"' && id[i] != '\\'
+	}
+	if valid {
+		return id
+	}
+	return rand.Text()
+}
+
+// accessLog resolves client addresses and logs requests as configured.
+type accessLog struct {
+	logger   *slog.Logger // nil if disabled
+	resolver *clientIPResolver
+}
+
+func newAccessLog(config HTTPConfig) (*accessLog, error) {
+	resolver, err := newClientIPResolver(config)
+	if err != nil {
+		return nil, err
+	}
+	a := &accessLog{resolver: resolver}
+
+	if config.AccessLog.Format != "off" {
+		h, err := newLogHandler(os.Stderr, config.AccessLog.Format)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %w", err)
+		}
+		a.logger = slog.New(h)
+	}
+	return a, nil
+}
+
+// serve serves the request with next, then logs it.
+func (a *accessLog) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	info := &common.RequestInfo{ClientIP: a.resolver.ClientIP(r), RequestID: requestID(r)}
+	r = r.WithContext(common.WithRequestInfo(r.Context(), info))
+	w.Header().Set("X-Request-ID", info.RequestID)
+	rec := &common.ResponseRecorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r)
+	if a.logger == nil {
+		return
+	}
+	a.logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.RequestURI()),
+		slog.Int("status", rec.StatusCode()),
+		slog.Int64("bytes", rec.Bytes),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("service", info.Service),
+		slog.String("client", info.ClientIP),
+		slog.String("user", info.User),
+		slog.String("user_agent", r.UserAgent()),
+		slog.String("request_id", info.RequestID),
+	)
+}
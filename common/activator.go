@@ -0,0 +1,17 @@
+package common
+
+// Activate calls Start on v if it implements Activator.
+func Activate(v any) error {
+	if activator, ok := v.(Activator); ok {
+		return activator.Start()
+	}
+	return nil
+}
+
+// Deactivate calls Stop on v if it implements Activator.
+func Deactivate(v any) error {
+	if activator, ok := v.(Activator); ok {
+		return activator.Stop()
+	}
+	return nil
+}
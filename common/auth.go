@@ -0,0 +1,462 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AuthConfig configures an Authenticator. At least one scheme must be set.
+type AuthConfig struct {
+	// Mode is "any" (the default) if a request must pass one of the schemes,
+	// or "all" if it must pass all of them
+	Mode string `json:"mode"`
+	// Local is what to do with requests from loopback addresses and Unix
+	// domain sockets: "check" them like others (the default), "allow" them
+	// without credentials, or "deny" them
+	Local string `json:"local"`
+
+	Bearer *BearerConfig `json:"bearer"`
+	Basic  *BasicConfig  `json:"basic"`
+	HMAC   *HMACConfig   `json:"hmac"`
+	IP     *IPConfig     `json:"ip"`
+}
+
+// Validate implements the Validator interface. The schemes validate
+// themselves.
+func (c AuthConfig) Validate() error {
+	var errs []error
+	switch c.Mode {
+	case "", "any", "all":
+	default:
+		errs = append(errs, &ConfigError{"mode", errors.New(`must be "any" or "all"`)})
+	}
+	switch c.Local {
+	case "", "check", "allow", "deny":
+	default:
+		errs = append(errs, &ConfigError{"local", errors.New(`must be "check", "allow" or "deny"`)})
+	}
+	if c.Bearer == nil && c.Basic == nil && c.HMAC == nil && c.IP == nil {
+		errs = append(errs, errors.New("at least one of bearer, basic, hmac and ip is required"))
+	}
+	return errors.Join(errs...)
+}
+
+// AuthResult is the outcome of checking the credentials of a request.
+type AuthResult int
+
+const (
+	// AuthFailed means that the request carries no valid credentials.
+	AuthFailed AuthResult = iota
+	// AuthOK means that the request carries valid credentials allowing it.
+	AuthOK
+	// AuthForbidden means that the request carries valid credentials that
+	// do not allow it, e.g. an expired token or one limited to other paths,
+	// so that other credentials would not help either.
+	AuthForbidden
+)
+
+// AuthScheme checks one kind of credentials.
+type AuthScheme interface {
+	// Authenticate checks the credentials of r, and returns the name of the
+	// user or token they belong to, if any.
+	Authenticate(r *http.Request) (name string, result AuthResult)
+	// Challenge returns the WWW-Authenticate header for requests that fail,
+	// or "" if no credentials would help.
+	Challenge() string
+}
+
+type BearerConfig struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// Validate implements the Validator interface.
+func (c BearerConfig) Validate() error {
+	if len(c.Tokens) == 0 {
+		return &ConfigError{"tokens", ErrMissing}
+	}
+	var errs []error
+	for i, t := range c.Tokens {
+		errs = append(errs, locateErrors(t.Validate(), fmt.Sprintf("tokens.%d", i))...)
+	}
+	return errors.Join(errs...)
+}
+
+type bearerScheme []scopedToken
+
+func (s bearerScheme) Authenticate(r *http.Request) (string, AuthResult) {
+	token := bearerToken(r.Header.Get("Authorization"))
+	if token == "" {
+		return "", AuthFailed
+	}
+	sum := sha256.Sum256([]byte(token))
+	for i := range s {
+		if subtle.ConstantTimeCompare(sum[:], s[i].hash[:]) == 1 {
+			if !s[i].allows(r) {
+				return s[i].name, AuthForbidden
+			}
+			return s[i].name, AuthOK
+		}
+	}
+	return "", AuthFailed
+}
+
+func (s bearerScheme) Challenge() string {
+	return "Bearer"
+}
+
+type BasicConfig struct {
+	// Realm is shown by browsers when asking for credentials
+	Realm string `json:"realm"`
+	// Users maps user names to bcrypt hashes, e.g. from "htpasswd -nB user"
+	Users map[string]string `json:"users"`
+}
+
+// Validate implements the Validator interface.
+func (c BasicConfig) Validate() error {
+	if len(c.Users) == 0 {
+		return &ConfigError{"users", ErrMissing}
+	}
+	var errs []error
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			errs = append(errs, &ConfigError{"users." + user, errors.New("invalid bcrypt hash")})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type basicScheme struct {
+	realm string
+	users map[string][]byte
+}
+
+// dummyHash is compared against for unknown users, so that they take as long
+// to reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("uniAPI"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (s *basicScheme) Authenticate(r *http.Request) (string, AuthResult) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", AuthFailed
+	}
+	hash, ok := s.users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", AuthFailed
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return "", AuthFailed
+	}
+	return user, AuthOK
+}
+
+func (s *basicScheme) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", s.realm)
+}
+
+type HMACConfig struct {
+	Secret string `json:"secret"`
+	// MaxSkew is how far X-Timestamp may be from the current time, 5m by
+	// default
+	MaxSkew string `json:"max-skew"`
+}
+
+// Validate implements the Validator interface.
+func (c HMACConfig) Validate() error {
+	var errs []error
+	if c.Secret == "" {
+		errs = append(errs, &ConfigError{"secret", ErrMissing})
+	}
+	if err := CheckDuration("max-skew", c.MaxSkew); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// maxSignedBody is the largest request body that HMAC signatures are
+// checked for.
+const maxSignedBody = 1 << 20
+
+// maxNonce is the longest X-Nonce accepted.
+const maxNonce = 128
+
+// hmacScheme accepts requests with an X-Timestamp header holding the Unix
+// time, an X-Nonce header unique to the request, and an X-Signature header
+// of the form "sha256=<hex>", holding the HMAC-SHA256 of the timestamp,
+// nonce, method, request URI and body, each followed by a newline except the
+// body. Nonces are remembered for as long as their timestamp is accepted, so
+// that signed requests cannot be replayed.
+type hmacScheme struct {
+	secret  []byte
+	maxSkew time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonce to when it may be forgotten
+	lastPrune time.Time
+}
+
+func (s *hmacScheme) Authenticate(r *http.Request) (string, AuthResult) {
+	if !s.verify(r) || !s.useNonce(r.Header.Get("X-Nonce")) {
+		return "", AuthFailed
+	}
+	return "", AuthOK
+}
+
+// useNonce reports whether nonce has not been seen in a valid request yet,
+// and remembers it.
+func (s *hmacScheme) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastPrune) > s.maxSkew {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.lastPrune = now
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	// A timestamp maxSkew ahead is accepted until maxSkew after it
+	s.nonces[nonce] = now.Add(2 * s.maxSkew)
+	return true
+}
+
+func (s *hmacScheme) verify(r *http.Request) bool {
+	sig, ok := strings.CutPrefix(r.Header.Get("X-Signature"), "sha256=")
+	if !ok {
+		return false
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	timestamp := r.Header.Get("X-Timestamp")
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > s.maxSkew || skew < -s.maxSkew {
+		return false
+	}
+	nonce := r.Header.Get("X-Nonce")
+	if nonce == "" || len(nonce) > maxNonce {
+		return false
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		// Put back what was read for the next handler
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil || len(body) > maxSignedBody {
+			return false
+		}
+	}
+	// The full URI as sent by the client, not the part below the mount path
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", timestamp, nonce, r.Method, uri)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func (s *hmacScheme) Challenge() string {
+	return ""
+}
+
+type IPConfig struct {
+	// Allow lists the addresses or CIDR prefixes of allowed clients
+	Allow []string `json:"allow"`
+}
+
+// Validate implements the Validator interface.
+func (c IPConfig) Validate() error {
+	if len(c.Allow) == 0 {
+		return &ConfigError{"allow", ErrMissing}
+	}
+	return CheckPrefixes("allow", c.Allow)
+}
+
+type ipScheme []netip.Prefix
+
+func (s ipScheme) Authenticate(r *http.Request) (string, AuthResult) {
+	addr := ClientAddr(r)
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return "", AuthOK
+		}
+	}
+	return "", AuthFailed
+}
+
+func (s ipScheme) Challenge() string {
+	return ""
+}
+
+// Authenticator checks requests against a set of schemes.
+type Authenticator struct {
+	all     bool
+	local   string
+	schemes []AuthScheme
+}
+
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		all:   config.Mode == "all",
+		local: config.Local,
+	}
+	if c := config.Bearer; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, errors.Join(locateErrors(err, "bearer")...)
+		}
+		var s bearerScheme
+		for _, t := range c.Tokens {
+			s = append(s, newScopedToken(t))
+		}
+		a.schemes = append(a.schemes, s)
+	}
+	if c := config.Basic; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, errors.Join(locateErrors(err, "basic")...)
+		}
+		s := &basicScheme{realm: c.Realm, users: make(map[string][]byte)}
+		if s.realm == "" {
+			s.realm = "uniAPI"
+		}
+		for user, hash := range c.Users {
+			s.users[user] = []byte(hash)
+		}
+		a.schemes = append(a.schemes, s)
+	}
+	if c := config.HMAC; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, errors.Join(locateErrors(err, "hmac")...)
+		}
+		a.schemes = append(a.schemes, &hmacScheme{
+			secret:  []byte(c.Secret),
+			maxSkew: ParseDurationDefault(c.MaxSkew, 5*time.Minute),
+			nonces:  make(map[string]time.Time),
+		})
+	}
+	if c := config.IP; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, errors.Join(locateErrors(err, "ip")...)
+		}
+		var s ipScheme
+		for _, p := range c.Allow {
+			prefix, _ := ParsePrefix(p)
+			s = append(s, prefix)
+		}
+		a.schemes = append(a.schemes, s)
+	}
+	return a, nil
+}
+
+// IsLocal reports whether the peer of r is on the same host, i.e. it
+// connected from a loopback address or through a Unix domain socket. The
+// client address resolved from headers is not considered, so requests
+// forwarded by a local proxy are all local.
+func IsLocal(r *http.Request) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// Authenticate checks r against the local policy and the schemes, and
+// returns the first name that a scheme authenticated it as. Credentials that
+// are valid but do not allow r make it AuthForbidden, unless another scheme
+// passes in mode "any".
+func (a *Authenticator) Authenticate(r *http.Request) (name string, result AuthResult) {
+	if a.local != "" && a.local != "check" && IsLocal(r) {
+		if a.local == "allow" {
+			return "", AuthOK
+		}
+		return "", AuthForbidden
+	}
+	forbidden, failed := false, false
+	for _, s := range a.schemes {
+		n, result := s.Authenticate(r)
+		if n != "" && name == "" {
+			name = n
+		}
+		switch result {
+		case AuthOK:
+			if !a.all {
+				return n, AuthOK
+			}
+		case AuthForbidden:
+			forbidden = true
+		default:
+			failed = true
+		}
+	}
+	switch {
+	case forbidden:
+		return name, AuthForbidden
+	case failed || !a.all:
+		return name, AuthFailed
+	}
+	return name, AuthOK
+}
+
+// Wrap returns a handler that passes authenticated requests to next, and
+// rejects others with 401 Unauthorized if credentials could help, or 403
+// Forbidden otherwise.
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, result := a.Authenticate(r)
+		if name != "" && result != AuthFailed {
+			GetRequestInfo(r.Context()).User = name
+		}
+		if result == AuthOK {
+			next.ServeHTTP(w, r)
+			return
+		}
+		code := http.StatusForbidden
+		if result == AuthFailed {
+			for _, s := range a.schemes {
+				if c := s.Challenge(); c != "" {
+					w.Header().Add("WWW-Authenticate", c)
+					code = http.StatusUnauthorized
+				}
+			}
+		}
+		Error(w, r, code, "")
+	})
+}
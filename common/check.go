@@ -0,0 +1,386 @@
+package common
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ConfigError is an error in the config, located by the dotted path of the
+// offending key, e.g. "services.teamspeak.service.timeout".
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ErrMissing is reported for required config values that are not set.
+var ErrMissing = errors.New("missing value")
+
+// Validator is optionally implemented by plugin configs to reject values
+// that decode fine but make no sense. Returning a *ConfigError locates the
+// error at a key relative to the config being validated.
+type Validator interface {
+	Validate() error
+}
+
+// checker is implemented by RegistryT of any type.
+type checker interface {
+	check(b json.RawMessage, path string, opts checkOptions) []error
+}
+
+// registryTag parses the "registry" struct tag of a config field. Such
+// fields hold the config of a nested plugin, either directly or as a map of
+// them. Maps tagged with the "shared" option define instances that other
+// configs can refer to by name, and those tagged with "mount" are services
+// of a server, which may also have the keys of MountConfig.
+func registryTag(f reflect.StructField) (name string, shared, mount bool) {
+	name, opts, _ := strings.Cut(f.Tag.Get("registry"), ",")
+	for _, opt := range strings.Split(opts, ",") {
+		switch opt {
+		case "shared":
+			shared = true
+		case "mount":
+			mount = true
+		}
+	}
+	return name, shared, mount
+}
+
+// registryFor returns the registry named by a "registry" struct tag.
+func registryFor(tag string) checker {
+	switch tag {
+	case "service":
+		return &Services
+	case "commander":
+		return &Commanders
+	case "streamer":
+		return &Streamers
+	}
+	return nil
+}
+
+var (
+	rawMessageType      = reflect.TypeFor[json.RawMessage]()
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isNull(b json.RawMessage) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) == 0 || bytes.Equal(b, []byte("null"))
+}
+
+// Check checks the config of a plugin in r without constructing it, and
+// returns all errors found.
+func (r *RegistryT[T]) Check(b json.RawMessage, path string) []error {
+	return r.check(b, path, checkOptions{nested: true})
+}
+
+func (r *RegistryT[T]) check(b json.RawMessage, path string, opts checkOptions) []error {
+	if opts.mount {
+		mount, rest, err := SplitMount(b)
+		if err != nil {
+			return []error{&ConfigError{path, describeJSONError(err)}}
+		}
+		opts.mount = false
+		return append(locateErrors(mount.Validate(), path), r.check(rest, path, opts)...)
+	}
+	var config struct {
+		TypeConfig
+		RefConfig
+		LogLevelConfig
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return []error{&ConfigError{path, describeJSONError(err)}}
+	}
+	if config.Ref != "" {
+		if err := r.checkRef(b, config.Ref); err != nil {
+			return []error{&ConfigError{path, err}}
+		}
+		if names, ok := opts.shared[r.kind]; ok && !names[config.Ref] {
+			return []error{&ConfigError{joinPath(path, "ref"), fmt.Errorf("unknown %s %q", r.kind, config.Ref)}}
+		}
+		return nil
+	}
+	var errs []error
+	if _, err := config.Level(); err != nil {
+		errs = append(errs, &ConfigError{joinPath(path, "log-level"), err})
+	}
+	if config.Type == "" {
+		return []error{&ConfigError{joinPath(path, "type"), fmt.Errorf("missing %s type", r.kind)}}
+	}
+	e, ok := r.entries[config.Type]
+	if !ok || e.newFunc == nil {
+		return []error{&ConfigError{joinPath(path, "type"), fmt.Errorf("unknown %s type %q", r.kind, config.Type)}}
+	}
+	if e.config == nil {
+		return errs
+	}
+	return append(errs, checkValue(e.config, b, path, checkOptions{nested: true, lenient: e.lenient, shared: opts.shared}, genericKeys...)...)
+}
+
+// checkRef checks that b is nothing but a reference to a shared instance.
+func (r *RegistryT[T]) checkRef(b json.RawMessage, name string) error {
+	if r.hide == nil {
+		return fmt.Errorf("%s references are not supported", r.kind)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err != nil || len(object) != 1 {
+		return fmt.Errorf("ref %q cannot be combined with other keys", name)
+	}
+	return nil
+}
+
+// CheckConfig checks b against the struct type of config, recursing into
+// nested plugin configs, and returns all errors found.
+func CheckConfig(config any, b json.RawMessage, path string) []error {
+	return checkValue(reflect.TypeOf(config), b, path, checkOptions{nested: true})
+}
+
+type checkOptions struct {
+	nested  bool // also check the configs of nested plugins
+	lenient bool // allow unknown keys
+	mount   bool // allow the keys of MountConfig
+
+	// shared maps registry kinds to the names of shared instances
+	shared map[string]map[string]bool
+}
+
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return errors.New("invalid syntax")
+	}
+	return err
+}
+
+// configFields returns the fields of struct type t by their JSON names,
+// including those promoted from embedded structs.
+func configFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for name, f := range configFields(ft) {
+					if _, ok := fields[name]; !ok {
+						fields[name] = f
+					}
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// lookupField finds a field the same way encoding/json does, preferring an
+// exact match over a case-insensitive one.
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func checkValue(t reflect.Type, b json.RawMessage, path string, opts checkOptions, allowedKeys ...string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if isNull(b) {
+		return nil
+	}
+
+	// Types with their own decoding are opaque to us
+	pt := reflect.PointerTo(t)
+	if t.Kind() != reflect.Struct || pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType) {
+		if err := json.Unmarshal(b, reflect.New(t).Interface()); err != nil {
+			return []error{&ConfigError{path, describeJSONError(err)}}
+		}
+		return validate(t, b, path)
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err != nil {
+		return []error{&ConfigError{path, errors.New("expected an object")}}
+	}
+	fields := configFields(t)
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	// Shared instances may be referred to from anywhere below this level
+	for _, key := range keys {
+		f, ok := lookupField(fields, key)
+		if !ok {
+			continue
+		}
+		if kind, shared, _ := registryTag(f); shared {
+			var m map[string]json.RawMessage
+			if json.Unmarshal(object[key], &m) != nil {
+				continue
+			}
+			names := make(map[string]bool)
+			for name := range m {
+				names[name] = true
+			}
+			shared := maps.Clone(opts.shared)
+			if shared == nil {
+				shared = make(map[string]map[string]bool)
+			}
+			shared[kind] = names
+			opts.shared = shared
+		}
+	}
+
+	var errs []error
+	for _, key := range keys {
+		p := joinPath(path, key)
+		f, ok := lookupField(fields, key)
+		if !ok {
+			if !opts.lenient && !slices.Contains(allowedKeys, key) {
+				errs = append(errs, &ConfigError{p, errors.New("unknown key")})
+			}
+			continue
+		}
+		errs = append(errs, checkField(f, object[key], p, opts)...)
+	}
+
+	// Nested plugins are mandatory where they are expected
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := fields[name]
+		kind, _, _ := registryTag(f)
+		if f.Type != rawMessageType || registryFor(kind) == nil {
+			continue
+		}
+		if !slices.ContainsFunc(keys, func(key string) bool { return strings.EqualFold(key, name) }) {
+			errs = append(errs, &ConfigError{joinPath(path, name), fmt.Errorf("missing %s", kind)})
+		}
+	}
+
+	return append(errs, validate(t, b, path)...)
+}
+
+func checkField(f reflect.StructField, b json.RawMessage, path string, opts checkOptions) []error {
+	kind, _, mount := registryTag(f)
+	reg := registryFor(kind)
+	if reg == nil {
+		return checkValue(f.Type, b, path, opts)
+	}
+	if !opts.nested {
+		// Checked when the nested plugin is constructed
+		return nil
+	}
+	if f.Type.Kind() == reflect.Map {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return []error{&ConfigError{path, errors.New("expected an object")}}
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		var errs []error
+		itemOpts := opts
+		itemOpts.mount = mount
+		for _, key := range keys {
+			errs = append(errs, reg.check(m[key], joinPath(path, key), itemOpts)...)
+		}
+		return errs
+	}
+	if isNull(b) {
+		return []error{&ConfigError{path, fmt.Errorf("missing %s", kind)}}
+	}
+	return reg.check(b, path, opts)
+}
+
+// validate runs the Validator of t, if any. Values that fail to decode are
+// skipped, as the decoding error is reported separately.
+func validate(t reflect.Type, b json.RawMessage, path string) []error {
+	v := reflect.New(t).Interface()
+	validator, ok := v.(Validator)
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil
+	}
+	return locateErrors(validator.Validate(), path)
+}
+
+// locateErrors prefixes the paths of ConfigErrors in err with path, looking
+// into errors joined with errors.Join.
+func locateErrors(err error, path string) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, locateErrors(err, path)...)
+		}
+		return errs
+	}
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return []error{&ConfigError{joinPath(path, configErr.Path), configErr.Err}}
+	}
+	return []error{&ConfigError{path, err}}
+}
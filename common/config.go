@@ -2,6 +2,8 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
 )
@@ -10,16 +12,40 @@ type TypeConfig struct {
 	Type string `json:"type"`
 }
 
+// RefConfig refers to a shared commander or streamer by name, in place of
+// its full config.
+type RefConfig struct {
+	Ref string `json:"ref"`
+}
+
+// LogLevelConfig may be set on any plugin to override the log level of it
+// and the plugins nested in it, e.g. "debug".
+type LogLevelConfig struct {
+	LogLevel string `json:"log-level"`
+}
+
+// Level returns the log level, or nil if unset.
+func (c LogLevelConfig) Level() (slog.Leveler, error) {
+	if c.LogLevel == "" {
+		return nil, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return nil, errors.New("invalid log level")
+	}
+	return level, nil
+}
+
 type ServiceConfig struct {
-	Service json.RawMessage `json:"service"`
+	Service json.RawMessage `json:"service" registry:"service"`
 }
 
 type CommanderConfig struct {
-	Commander json.RawMessage `json:"commander"`
+	Commander json.RawMessage `json:"commander" registry:"commander"`
 }
 
 type StreamerConfig struct {
-	Streamer json.RawMessage `json:"streamer"`
+	Streamer json.RawMessage `json:"streamer" registry:"streamer"`
 }
 
 func DefaultConfigPath() (string, error) {
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+type mountPathKey struct{}
+
+// WithMountPath returns a copy of ctx carrying the path that the service
+// handling the request is mounted at.
+func WithMountPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, mountPathKey{}, path)
+}
+
+// MountPath returns the path that the service handling the request with ctx
+// is mounted at, e.g. "/games/minecraft", or "" outside of a server.
+func MountPath(ctx context.Context) string {
+	path, _ := ctx.Value(mountPathKey{}).(string)
+	return path
+}
+
+// RequestInfo describes a request as it is being handled. The main program
+// puts it in the request context, and servers fill in the service that
+// handles the request, so that it ends up in the access log.
+type RequestInfo struct {
+	// ClientIP is the address of the client, resolved through the headers
+	// set by trusted proxies
+	ClientIP string
+	// Service is the path of the service handling the request
+	Service string
+	// User is the name of the user or token that the request authenticated
+	// as, if any
+	User string
+	// RequestID identifies the request in responses and log lines
+	RequestID string
+}
+
+type subjectKey struct{}
+
+// WithSubject returns a copy of ctx carrying the subject that the request
+// was authenticated for, e.g. the "sub" claim of a JWT.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// Subject returns the subject set by WithSubject, or "".
+func Subject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// GetRequestInfo returns the RequestInfo of the request with ctx, or a fresh
+// one if the request did not come through the main program.
+func GetRequestInfo(ctx context.Context) *RequestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info
+	}
+	return new(RequestInfo)
+}
+
+// RequestID returns the ID of the request with ctx, or "" if it did not come
+// through the main program.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info.RequestID
+	}
+	return ""
+}
+
+// ClientAddr returns the address of the client of r, as resolved by the main
+// program, or else the address of the peer. It is invalid if the peer
+// connected through a Unix domain socket.
+func ClientAddr(r *http.Request) netip.Addr {
+	s := GetRequestInfo(r.Context()).ClientIP
+	if s == "" {
+		s = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(s); err == nil {
+			s = host
+		}
+	}
+	addr, _ := netip.ParseAddr(s)
+	return addr.Unmap()
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Mount is a service mounted at Path, which is made up of the prefixes of
+// the server and any nested servers it is found under. Host and Methods are
+// set if the service is restricted to them.
+type Mount struct {
+	Path    string   `json:"path"`
+	Type    string   `json:"type"`
+	Host    string   `json:"host,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// RuntimeStatus describes the running instance.
+type RuntimeStatus struct {
+	Version         string    `json:"version"`
+	LoadedAt        time.Time `json:"loaded_at"`
+	LastReloadAt    time.Time `json:"last_reload_at,omitzero"`
+	LastReloadError string    `json:"last_reload_error,omitempty"`
+	Mounts          []Mount   `json:"mounts"`
+}
+
+// Controller gives services like admin access to the running instance. It
+// is provided by the main program with SetController.
+type Controller interface {
+	Status() RuntimeStatus
+	// Reload reloads the config the same way SIGHUP does.
+	Reload() error
+}
+
+var (
+	controllerMu sync.RWMutex
+	controller   Controller
+)
+
+func SetController(c Controller) {
+	controllerMu.Lock()
+	defer controllerMu.Unlock()
+	controller = c
+}
+
+// GetController returns the Controller set by the main program, or nil.
+func GetController() Controller {
+	controllerMu.RLock()
+	defer controllerMu.RUnlock()
+	return controller
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrorResponse is the body of error responses, as {"error": {...}}.
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Service is the path of the service that responded, if any
+	Service   string `json:"service,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// wantsText reports whether the client of r accepts plain text but not JSON.
+func wantsText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/") && !strings.Contains(accept, "json") && !strings.Contains(accept, "*/*")
+}
+
+// Error responds to r with an error of code, described by message, or by
+// the status text of code if message is empty. The response is JSON, or
+// plain text for clients that do not accept JSON. Headers already set on w,
+// like WWW-Authenticate, are kept.
+func Error(w http.ResponseWriter, r *http.Request, code int, message string) {
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if wantsText(r) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintln(w, message)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error ErrorResponse `json:"error"`
+	}{ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Service:   GetRequestInfo(r.Context()).Service,
+		RequestID: RequestID(r.Context()),
+	}})
+}
+
+// IsTimeout reports whether err is the result of a timeout or deadline.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// BackendError responds to r for err from what the service gets its data
+// from, like a commander or an upstream API: 504 Gateway Timeout if it timed
+// out, or 502 Bad Gateway otherwise. The details of err are left out of the
+// response, so the caller should log it.
+func BackendError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusBadGateway
+	if IsTimeout(err) {
+		code = http.StatusGatewayTimeout
+	}
+	Error(w, r, code, "")
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"io"
 	"net/http"
 )
@@ -21,7 +22,104 @@ type Stream interface {
 	io.ReadWriteCloser
 }
 
+// ContextCommander is optionally implemented by Commanders whose commands
+// can be cancelled, e.g. when the client of the request goes away.
+type ContextCommander interface {
+	Commander
+	ExecuteContext(ctx context.Context, cmd string) (string, error)
+}
+
+// ContextStreamer is optionally implemented by Streamers whose connecting
+// can be cancelled. ctx only applies to connecting, not to the Stream.
+type ContextStreamer interface {
+	Streamer
+	ConnectContext(ctx context.Context) (Stream, error)
+}
+
+// ExecuteContext runs cmd with c, giving up once ctx is done. Commanders
+// that do not implement ContextCommander are left to finish in the
+// background.
+func ExecuteContext(ctx context.Context, c Commander, cmd string) (string, error) {
+	if cc, ok := c.(ContextCommander); ok {
+		return cc.ExecuteContext(ctx, cmd)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := c.Execute(cmd)
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ConnectContext connects with s, giving up once ctx is done. For Streamers
+// that do not implement ContextStreamer, a Stream that is connected too late
+// is closed.
+func ConnectContext(ctx context.Context, s Streamer) (Stream, error) {
+	if cs, ok := s.(ContextStreamer); ok {
+		return cs.ConnectContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		stream Stream
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stream, err := s.Connect()
+		done <- result{stream, err}
+	}()
+	select {
+	case r := <-done:
+		return r.stream, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.stream.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
 type Activator interface {
 	Start() error
 	Stop() error
 }
+
+// Describer is optionally implemented by services to tell what they serve
+// in the index of a server.
+type Describer interface {
+	Describe() string
+}
+
+// Wrapper is optionally implemented by services that pass requests on to
+// another service, like auth, so that servers can look through them.
+type Wrapper interface {
+	Unwrap() Service
+}
+
+// Describe returns the description of v if it implements Describer, or else
+// that of the service it wraps.
+func Describe(v any) string {
+	switch v := v.(type) {
+	case Describer:
+		return v.Describe()
+	case Wrapper:
+		return Describe(v.Unwrap())
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// levelHandler overrides the minimum level of the handler it wraps.
+type levelHandler struct {
+	level slog.Leveler
+	slog.Handler
+}
+
+func (h levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.level, h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.level, h.Handler.WithGroup(name)}
+}
+
+// requestHandler adds the ID of the request being served, if any, to log
+// records made with its context.
+type requestHandler struct {
+	slog.Handler
+}
+
+func (h requestHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
+
+// logScope is what is being constructed: the path of the service (e.g.
+// "/games/minecraft") or shared instance (e.g. "commanders.mc-rcon"), and
+// the type and log level of the innermost plugin.
+type logScope struct {
+	path   string
+	typ    string
+	level  slog.Leveler
+	logger *slog.Logger
+}
+
+var (
+	logMu      sync.Mutex
+	logHandler slog.Handler = slog.Default().Handler()
+	logLevel                = new(slog.LevelVar)
+	logScopes  []logScope
+)
+
+// SetLogHandler sets the handler that all loggers write to, which should
+// accept all levels, and makes it the default for slog and log. Plugins
+// without a log-level of their own log at the level of LogLevel. Records
+// logged with the context of a request get its ID as "request_id".
+func SetLogHandler(h slog.Handler) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	h = requestHandler{h}
+	logHandler = h
+	slog.SetDefault(slog.New(levelHandler{logLevel, h}))
+}
+
+// LogLevel is the level of the default logger, which may be changed at any
+// time.
+func LogLevel() *slog.LevelVar {
+	return logLevel
+}
+
+func currentScope() logScope {
+	if len(logScopes) == 0 {
+		return logScope{level: logLevel}
+	}
+	return logScopes[len(logScopes)-1]
+}
+
+func pushScope(s logScope) (exit func()) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	attrs := []any{"path", s.path}
+	if s.typ != "" {
+		attrs = append(attrs, "type", s.typ)
+	}
+	s.logger = slog.New(levelHandler{s.level, logHandler}).With(attrs...)
+	n := len(logScopes)
+	logScopes = append(logScopes, s)
+	return func() {
+		logMu.Lock()
+		defer logMu.Unlock()
+		logScopes = logScopes[:n]
+	}
+}
+
+// EnterPath makes path the path of the plugins constructed until exit is
+// called. Plugins are constructed on a single goroutine, so scopes nest.
+func EnterPath(path string) (exit func()) {
+	logMu.Lock()
+	s := currentScope()
+	logMu.Unlock()
+	return pushScope(logScope{path: path, level: s.level})
+}
+
+// CurrentPath returns the path set by the innermost EnterPath.
+func CurrentPath() string {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return currentScope().path
+}
+
+// EnterPlugin is called by the registry while constructing a plugin of type
+// typ, and by plugins that update themselves without it, like servers
+// reusing their services. A nil level inherits that of the enclosing plugin.
+func EnterPlugin(typ string, level slog.Leveler) (exit func()) {
+	logMu.Lock()
+	s := currentScope()
+	logMu.Unlock()
+	if level == nil {
+		level = s.level
+	}
+	return pushScope(logScope{path: s.path, typ: typ, level: level})
+}
+
+// Logger returns the logger for the plugin being constructed, tagged with
+// its path and type, at the level set by log-level in its config. Plugins
+// call it from their constructor and keep the result.
+func Logger() *slog.Logger {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if s := currentScope(); s.logger != nil {
+		return s.logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,244 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A minimal implementation of Prometheus counters, gauges and histograms,
+// written out in the text exposition format by WriteMetrics.
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	metricsMu sync.Mutex
+	metrics   = make(map[string]*metricVec)
+)
+
+type series struct {
+	labels []string
+	value  float64 // counters and gauges, or the sum of a histogram
+	counts []uint64
+	count  uint64
+}
+
+type metricVec struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newMetricVec(name, help, typ string, buckets []float64, labels []string) *metricVec {
+	v := &metricVec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if _, ok := metrics[name]; ok {
+		panic("metric " + name + " is already registered")
+	}
+	metrics[name] = v
+	return v
+}
+
+// get returns the series for the label values, creating it if necessary.
+// v.mu must be held.
+func (v *metricVec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: slices.Clone(values)}
+		if v.buckets != nil {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+type CounterVec struct{ v *metricVec }
+
+// NewCounterVec registers a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newMetricVec(name, help, "counter", nil, labels)}
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.get(values).value += delta
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+type GaugeVec struct{ v *metricVec }
+
+// NewGaugeVec registers a gauge with the given label names.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newMetricVec(name, help, "gauge", nil, labels)}
+}
+
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	g.v.get(values).value = value
+}
+
+type HistogramVec struct{ v *metricVec }
+
+// NewHistogramVec registers a histogram with the given upper bounds of its
+// buckets, in increasing order, and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{newMetricVec(name, help, "histogram", buckets, labels)}
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.get(values)
+	for i, bound := range h.v.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// DeleteSeries removes the series of all metrics whose label name has value,
+// such as those labelled with the path of a service that was removed.
+func DeleteSeries(name, value string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, v := range metrics {
+		i := slices.Index(v.labels, name)
+		if i < 0 {
+			continue
+		}
+		v.mu.Lock()
+		for key, s := range v.series {
+			if s.labels[i] == value {
+				delete(v.series, key)
+			}
+		}
+		v.mu.Unlock()
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names)+len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (v *metricVec) write(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.series) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.typ)
+	for _, key := range keys {
+		s := v.series[key]
+		if v.typ != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range v.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labels), formatFloat(s.value))
+		fmt.Fprintf(&b, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labels), s.count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMetrics writes all registered metrics in the Prometheus text format.
+func WriteMetrics(w io.Writer) error {
+	metricsMu.Lock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	metricsMu.Unlock()
+	slices.Sort(names)
+
+	for _, name := range names {
+		metricsMu.Lock()
+		v := metrics[name]
+		metricsMu.Unlock()
+		if err := v.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Game status gauges, set by game services whenever their status is
+// requested and labelled with the path the service is mounted at.
+var (
+	GameUp = NewGaugeVec("uniapi_game_up",
+		"Whether the last status request to the game server succeeded.", "service")
+	GamePlayers = NewGaugeVec("uniapi_game_players",
+		"Players online as of the last status request.", "service")
+	GameMaxPlayers = NewGaugeVec("uniapi_game_max_players",
+		"Maximum number of players as of the last status request.", "service")
+	GameBots = NewGaugeVec("uniapi_game_bots",
+		"Bots online as of the last status request.", "service")
+)
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MountConfig holds the keys that a service in a server may have besides
+// its own config, which control the requests routed to it.
+type MountConfig struct {
+	// Prefix is the path the service is mounted at, its key by default. It
+	// may have several segments, and "/" mounts the service at the root.
+	Prefix *string `json:"prefix"`
+	// Host restricts the service to requests for a host name, or for any
+	// subdomain with a leading "*."
+	Host string `json:"host"`
+	// Methods restricts the service to the HTTP methods listed. GET also
+	// allows HEAD.
+	Methods []string `json:"methods"`
+}
+
+// mountKeys are the keys of MountConfig.
+var mountKeys = []string{"prefix", "host", "methods"}
+
+// isToken reports whether s is a token as in RFC 9110, like HTTP methods.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate implements the Validator interface.
+func (c MountConfig) Validate() error {
+	var errs []error
+	if c.Host != "" {
+		name := strings.TrimPrefix(c.Host, "*.")
+		if name == "" || strings.ContainsAny(name, "/:*") {
+			errs = append(errs, &ConfigError{Path: "host", Err: errors.New("invalid host")})
+		}
+	}
+	for i, m := range c.Methods {
+		if !isToken(m) {
+			errs = append(errs, &ConfigError{Path: fmt.Sprintf("methods.%d", i), Err: errors.New("invalid method")})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SplitMount separates the mount keys of a service config in a server from
+// the config of the service itself. b is returned as is if it has none.
+func SplitMount(b json.RawMessage) (MountConfig, json.RawMessage, error) {
+	var mount MountConfig
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err != nil {
+		return mount, b, nil
+	}
+	found := false
+	for _, key := range mountKeys {
+		if _, ok := object[key]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return mount, b, nil
+	}
+	if err := json.Unmarshal(b, &mount); err != nil {
+		return mount, b, err
+	}
+	for _, key := range mountKeys {
+		delete(object, key)
+	}
+	rest, err := json.Marshal(object)
+	return mount, rest, err
+}
@@ -1,23 +1,79 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 )
 
 type NewFuncT[T any] func(json.RawMessage) (T, error)
 
+type registryEntry[T any] struct {
+	newFunc NewFuncT[T]
+	config  reflect.Type
+	lenient bool
+}
+
 type RegistryT[T any] struct {
-	entries map[string]NewFuncT[T]
+	kind    string
+	entries map[string]registryEntry[T]
+
+	// Instances that configs can refer to with {ref: name}. They are owned
+	// by whoever called Share, so they are handed out wrapped by hide, which
+	// keeps the referring plugin from starting or stopping them.
+	shared map[string]T
+	hide   func(T) T
+}
+
+func NewRegistry[T any](kind string) RegistryT[T] {
+	return RegistryT[T]{kind: kind, entries: make(map[string]registryEntry[T])}
 }
 
 func (r *RegistryT[T]) Register(name string, newFunc NewFuncT[T]) {
-	r.entries[name] = newFunc
+	e := r.entries[name]
+	e.newFunc = newFunc
+	r.entries[name] = e
+}
+
+// RegisterConfig declares the struct that plugin name decodes its config
+// into, so that configs can be checked without constructing the plugin.
+func (r *RegistryT[T]) RegisterConfig(name string, config any) {
+	e := r.entries[name]
+	e.config = reflect.TypeOf(config)
+	r.entries[name] = e
+}
+
+// Kind returns the kind of plugins in r, e.g. "service".
+func (r *RegistryT[T]) Kind() string {
+	return r.kind
+}
+
+// AllowUnknownKeys opts plugin name out of strict config decoding, for
+// plugins that pass their config through to something else.
+func (r *RegistryT[T]) AllowUnknownKeys(name string) {
+	e := r.entries[name]
+	e.lenient = true
+	r.entries[name] = e
 }
 
 func (r *RegistryT[T]) Get(name string) (NewFuncT[T], bool) {
-	newFunc, ok := r.entries[name]
-	return newFunc, ok
+	e, ok := r.entries[name]
+	return e.newFunc, ok && e.newFunc != nil
+}
+
+// Names returns the sorted names of all registered plugins.
+func (r *RegistryT[T]) Names() []string {
+	names := make([]string, 0, len(r.entries))
+	for name, e := range r.entries {
+		if e.newFunc != nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
 }
 
 func (r *RegistryT[T]) New(name string, b json.RawMessage) (T, error) {
@@ -28,24 +84,105 @@ func (r *RegistryT[T]) New(name string, b json.RawMessage) (T, error) {
 	return newFunc(b)
 }
 
+// genericKeys are the keys allowed in the config of any plugin.
+var genericKeys = []string{"type", "log-level"}
+
+// NewFromConfig constructs the plugin named by the "type" key of b. Unless
+// the plugin opted out, keys that do not map to a field of its registered
+// config are rejected, and the config is validated if it implements
+// Validator.
 func (r *RegistryT[T]) NewFromConfig(b json.RawMessage) (T, error) {
-	var config TypeConfig
+	var config struct {
+		TypeConfig
+		RefConfig
+		LogLevelConfig
+	}
 	err := json.Unmarshal(b, &config)
 	if err != nil {
 		return *new(T), err
 	}
+	if config.Ref != "" {
+		return r.lookupShared(b, config.Ref)
+	}
+	level, err := config.Level()
+	if err != nil {
+		return *new(T), err
+	}
+	if err := r.CheckShallow(config.Type, b); err != nil {
+		return *new(T), err
+	}
+	defer EnterPlugin(config.Type, level)()
 	return r.New(config.Type, b)
 }
 
+// CheckShallow checks b strictly against the registered config of plugin
+// name, like NewFromConfig does, but without looking into nested plugins.
+func (r *RegistryT[T]) CheckShallow(name string, b json.RawMessage) error {
+	e, ok := r.entries[name]
+	if !ok || e.config == nil {
+		return nil
+	}
+	if errs := checkValue(e.config, b, "", checkOptions{lenient: e.lenient}, genericKeys...); len(errs) > 0 {
+		return fmt.Errorf("%s %q: %w", r.kind, name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Share makes instances available to configs as {ref: name}, replacing the
+// previously shared ones. The caller remains responsible for starting and
+// stopping them.
+func (r *RegistryT[T]) Share(instances map[string]T) {
+	r.shared = instances
+}
+
+func (r *RegistryT[T]) lookupShared(b json.RawMessage, name string) (T, error) {
+	if err := r.checkRef(b, name); err != nil {
+		return *new(T), err
+	}
+	instance, ok := r.shared[name]
+	if !ok {
+		return *new(T), fmt.Errorf("unknown %s %q", r.kind, name)
+	}
+	return r.hide(instance), nil
+}
+
+// sharedCommander and sharedStreamer hide the Activator of shared instances.
+type sharedCommander struct {
+	Commander
+}
+
+type sharedStreamer struct {
+	Streamer
+}
+
+func (c sharedCommander) ExecuteContext(ctx context.Context, cmd string) (string, error) {
+	return ExecuteContext(ctx, c.Commander, cmd)
+}
+
+func (s sharedStreamer) ConnectContext(ctx context.Context) (Stream, error) {
+	return ConnectContext(ctx, s.Streamer)
+}
+
 var (
-	Services   = RegistryT[Service]{entries: make(map[string]NewFuncT[Service])}
-	Commanders = RegistryT[Commander]{entries: make(map[string]NewFuncT[Commander])}
-	Streamers  = RegistryT[Streamer]{entries: make(map[string]NewFuncT[Streamer])}
+	Services   = NewRegistry[Service]("service")
+	Commanders = NewRegistry[Commander]("commander")
+	Streamers  = NewRegistry[Streamer]("streamer")
 )
 
+func init() {
+	Commanders.hide = func(c Commander) Commander { return sharedCommander{c} }
+	Streamers.hide = func(s Streamer) Streamer { return sharedStreamer{s} }
+}
+
 // Convenience functions
+
+// NewService decodes config into T strictly, allowing only the generic keys
+// like "type" in addition to the fields of T.
 func NewService[T Service](config json.RawMessage) (Service, error) {
 	var s T
+	if errs := checkValue(reflect.TypeFor[T](), config, "", checkOptions{}, genericKeys...); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 	err := json.Unmarshal(config, &s)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,40 @@
+package common
+
+import "net/http"
+
+// ResponseRecorder records the status code and size of a response while
+// passing it through.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+func (w *ResponseRecorder) WriteHeader(code int) {
+	if w.Status == 0 {
+		w.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseRecorder) Write(b []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.Bytes += int64(n)
+	return n, err
+}
+
+// StatusCode returns the status code written, which defaults to 200.
+func (w *ResponseRecorder) StatusCode() int {
+	if w.Status == 0 {
+		return http.StatusOK
+	}
+	return w.Status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,39 +1,162 @@
 package common
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"path"
+	"slices"
 	"strings"
+	"time"
 )
 
-func ValidateToken(header string, tokens []string) bool {
+// bearerToken extracts the token from an Authorization header, which may be
+// "Bearer <token>", "Token <token>" or just the token.
+func bearerToken(header string) string {
 	parts := strings.Fields(header)
-	if len(parts) == 0 || len(parts) > 2 {
-		return false
+	switch {
+	case len(parts) == 1:
+		return parts[0]
+	case len(parts) == 2 && (strings.EqualFold(parts[0], "bearer") || strings.EqualFold(parts[0], "token")):
+		return parts[1]
 	}
-	token := parts[0]
-	switch strings.ToLower(parts[0]) {
-	case "bearer", "token":
-		token = parts[1]
+	return ""
+}
+
+func ValidateToken(header string, tokens []string) bool {
+	token := bearerToken(header)
+	if token == "" {
+		return false
 	}
+	found := false
 	for _, t := range tokens {
-		if token == t {
-			return true
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			found = true
 		}
 	}
-	return false
+	return found
+}
+
+// HashToken returns the hash of token as expected in Token.Hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
 
-type TokenProtectedHandler struct {
-	Next   http.Handler
-	Tokens []string
+// Token is a bearer token in the config, given either as the token itself or
+// as an object with the hash of the token and what it may be used for.
+type Token struct {
+	// Name identifies the token in logs
+	Name string `json:"name"`
+	// Hash is the hash of the token as returned by HashToken
+	Hash string `json:"hash"`
+	// Token is the token itself, for those not worth hashing
+	Token string `json:"token"`
+	// Expires is the date (2006-01-02) or time (RFC 3339) from which the
+	// token is no longer accepted
+	Expires string `json:"expires"`
+	// Methods and Paths restrict the token to requests with these methods,
+	// and to these paths and below, relative to the protected service
+	Methods []string `json:"methods"`
+	Paths   []string `json:"paths"`
 }
 
-// ServeHTTP implements the http.Handler interface.
-func (h *TokenProtectedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("CF-Connecting-IP") != "" &&
-		!ValidateToken(r.Header.Get("Authorization"), h.Tokens) {
-		w.WriteHeader(http.StatusForbidden)
-		return
+func (t *Token) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = Token{Token: s}
+		return nil
 	}
-	h.Next.ServeHTTP(w, r)
+	type token Token
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode((*token)(t))
+}
+
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Validate implements the Validator interface.
+func (t Token) Validate() error {
+	var errs []error
+	switch {
+	case t.Hash == "" && t.Token == "":
+		errs = append(errs, &ConfigError{"hash", ErrMissing})
+	case t.Hash != "" && t.Token != "":
+		errs = append(errs, errors.New("hash and token are mutually exclusive"))
+	case t.Hash != "":
+		sum, ok := strings.CutPrefix(t.Hash, "sha256:")
+		if b, err := hex.DecodeString(sum); !ok || err != nil || len(b) != sha256.Size {
+			errs = append(errs, &ConfigError{"hash", errors.New(`expected "sha256:" and 64 hex digits`)})
+		}
+	}
+	if t.Expires != "" {
+		if _, err := parseExpiry(t.Expires); err != nil {
+			errs = append(errs, &ConfigError{"expires", errors.New("expected a date or an RFC 3339 time")})
+		}
+	}
+	for i, p := range t.Paths {
+		if !strings.HasPrefix(p, "/") {
+			errs = append(errs, &ConfigError{fmt.Sprintf("paths.%d", i), errors.New("must start with /")})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scopedToken is a Token ready for matching.
+type scopedToken struct {
+	name    string
+	hash    [sha256.Size]byte
+	expires time.Time
+	methods []string
+	paths   []string
+}
+
+func newScopedToken(t Token) scopedToken {
+	s := scopedToken{name: t.Name, paths: t.Paths}
+	if t.Hash != "" {
+		b, _ := hex.DecodeString(strings.TrimPrefix(t.Hash, "sha256:"))
+		copy(s.hash[:], b)
+	} else {
+		s.hash = sha256.Sum256([]byte(t.Token))
+	}
+	if t.Expires != "" {
+		s.expires, _ = parseExpiry(t.Expires)
+	}
+	for _, m := range t.Methods {
+		s.methods = append(s.methods, strings.ToUpper(m))
+	}
+	return s
+}
+
+// allows reports whether the token may be used for r.
+func (s *scopedToken) allows(r *http.Request) bool {
+	if !s.expires.IsZero() && !time.Now().Before(s.expires) {
+		return false
+	}
+	if s.methods != nil && !slices.Contains(s.methods, r.Method) {
+		return false
+	}
+	if s.paths == nil {
+		return true
+	}
+	// Cleaned like the server does when looking up services, so that ".."
+	// cannot escape the allowed paths
+	p := path.Clean("/" + r.URL.Path)
+	for _, allowed := range s.paths {
+		allowed = path.Clean(allowed)
+		if p == allowed || allowed == "/" || strings.HasPrefix(p, allowed+"/") {
+			return true
+		}
+	}
+	return false
 }
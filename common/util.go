@@ -1,7 +1,10 @@
 package common
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 )
 
@@ -11,8 +14,44 @@ func ParseDurationDefault(s string, def time.Duration) time.Duration {
 	}
 	dur, err := time.ParseDuration(s)
 	if err != nil {
-		log.Printf("Invalid timeout %s, using %s\n", s, def)
+		Logger().Warn("Invalid duration, using default", "default", def)
 		return def
 	}
 	return dur
 }
+
+// CheckDuration reports an error at key if s is neither empty nor a valid
+// duration, for use in config validation alongside ParseDurationDefault.
+func CheckDuration(key, s string) error {
+	if s == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return &ConfigError{key, errors.New("invalid duration")}
+	}
+	return nil
+}
+
+// ParsePrefix parses either a CIDR prefix or a single IP address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// CheckPrefixes reports an error at key.i for each element i of prefixes
+// that ParsePrefix rejects.
+func CheckPrefixes(key string, prefixes []string) error {
+	var errs []error
+	for i, s := range prefixes {
+		if _, err := ParsePrefix(s); err != nil {
+			errs = append(errs, &ConfigError{fmt.Sprintf("%s.%d", key, i), errors.New("invalid address or prefix")})
+		}
+	}
+	return errors.Join(errs...)
+}
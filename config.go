@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/iBug/uniAPI/common"
+	"github.com/iBug/uniAPI/server"
+	yamlv3 "go.yaml.in/yaml/v3"
+	"sigs.k8s.io/yaml"
+)
+
+type Config struct {
+	Server     HTTPConfig                 `json:"server"`
+	Log        LogConfig                  `json:"log"`
+	Commanders map[string]json.RawMessage `json:"commanders" registry:"commander,shared"`
+	Streamers  map[string]json.RawMessage `json:"streamers" registry:"streamer,shared"`
+	Services   server.ServiceSet          `json:"services" registry:"service,mount"`
+
+	source *configSource
+}
+
+// mergeableKeys are the top-level maps that included files may add to.
+var mergeableKeys = []string{"commanders", "streamers", "services"}
+
+// configSource is the config file merged with the files it includes.
+type configSource struct {
+	JSON  []byte
+	Files []string
+
+	// origins maps paths like "services.minecraft" to "file:line"
+	origins map[string]string
+}
+
+// Locate prefixes err with the file and line where path, or the nearest
+// entry containing it, is defined.
+func (s *configSource) Locate(path string, err error) error {
+	if s == nil {
+		return err
+	}
+	for p := path; p != ""; {
+		if origin, ok := s.origins[p]; ok {
+			return fmt.Errorf("%s: %w", origin, err)
+		}
+		i := strings.LastIndexByte(p, '.')
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return err
+}
+
+// includeDir returns the directory whose *.yml files are always included,
+// e.g. ~/.config/uniAPI.d for ~/.config/uniAPI.yml.
+func includeDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+func parseYAMLFile(path string) (*yamlv3.Node, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := resolveSecrets(&doc, filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// Empty file
+		doc.Kind = yamlv3.DocumentNode
+		doc.Content = []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+	return &doc, nil
+}
+
+// takeKey removes key from mapping node m and returns its value, or nil.
+func takeKey(m *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			value := m.Content[i+1]
+			m.Content = slices.Delete(m.Content, i, i+2)
+			return value
+		}
+	}
+	return nil
+}
+
+func getKey(m *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// includedFiles expands the include patterns, relative to dir, and appends
+// the files in the include directory.
+func includedFiles(include *yamlv3.Node, dir, incDir string) ([]string, error) {
+	var patterns []*yamlv3.Node
+	switch {
+	case include == nil:
+	case include.Kind == yamlv3.ScalarNode:
+		patterns = []*yamlv3.Node{include}
+	case include.Kind == yamlv3.SequenceNode:
+		patterns = include.Content
+	default:
+		return nil, fmt.Errorf("line %d: include must be a pattern or a list of patterns", include.Line)
+	}
+
+	var files []string
+	for _, ext := range []string{"*.yml", "*.yaml"} {
+		matches, _ := filepath.Glob(filepath.Join(incDir, ext))
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	for _, p := range patterns {
+		pattern := p.Value
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: include %q: %w", p.Line, p.Value, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(p.Value, `*?[\`) {
+			return nil, fmt.Errorf("line %d: include %q: file not found", p.Line, p.Value)
+		}
+		slices.Sort(matches)
+		files = append(files, matches...)
+	}
+
+	// The same file may be matched more than once
+	seen := make(map[string]bool)
+	return slices.DeleteFunc(files, func(f string) bool {
+		if seen[f] {
+			return true
+		}
+		seen[f] = true
+		return false
+	}), nil
+}
+
+// readConfig reads the config file, merges the files it includes into it,
+// and converts the result to JSON.
+func readConfig(path string) (*configSource, error) {
+	if path == "" {
+		var err error
+		path, err = common.DefaultConfigPath()
+		if err != nil {
+			return nil, err
+		}
+
+	}
+	doc, err := parseYAMLFile(path)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	src := &configSource{
+		Files:   []string{path},
+		origins: make(map[string]string),
+	}
+	for _, key := range mergeableKeys {
+		if m := getKey(root, key); m != nil && m.Kind == yamlv3.MappingNode {
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				src.origins[key+"."+m.Content[i].Value] = fmt.Sprintf("%s:%d", path, m.Content[i].Line)
+			}
+		}
+	}
+
+	files, err := includedFiles(takeKey(root, "include"), filepath.Dir(path), includeDir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, file := range files {
+		if err := src.merge(root, file); err != nil {
+			return nil, err
+		}
+		src.Files = append(src.Files, file)
+	}
+
+	b, err := yamlv3.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	src.JSON, err = yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// merge adds the entries of the mergeable maps in file to root.
+func (s *configSource) merge(root *yamlv3.Node, file string) error {
+	doc, err := parseYAMLFile(file)
+	if err != nil {
+		return err
+	}
+	inc := doc.Content[0]
+	for i := 0; i+1 < len(inc.Content); i += 2 {
+		keyNode, value := inc.Content[i], inc.Content[i+1]
+		key := keyNode.Value
+		if !slices.Contains(mergeableKeys, key) {
+			return fmt.Errorf("%s:%d: %q is not allowed in included files", file, keyNode.Line, key)
+		}
+		if value.Kind != yamlv3.MappingNode {
+			return fmt.Errorf("%s:%d: %q must be a mapping", file, keyNode.Line, key)
+		}
+		target := getKey(root, key)
+		if target == nil {
+			target = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+			root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}, target)
+		} else if target.Kind != yamlv3.MappingNode {
+			return fmt.Errorf("%q must be a mapping", key)
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			name := value.Content[j]
+			p := key + "." + name.Value
+			origin := fmt.Sprintf("%s:%d", file, name.Line)
+			if prev, ok := s.origins[p]; ok {
+				return fmt.Errorf("%s: %s is already defined at %s", origin, p, prev)
+			}
+			s.origins[p] = origin
+			target.Content = append(target.Content, name, value.Content[j+1])
+		}
+	}
+	return nil
+}
+
+func loadConfig(path string) (*Config, error) {
+	src, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = json.Unmarshal(src.JSON, &config)
+	if err != nil {
+		return nil, err
+	}
+	config.source = src
+	return &config, nil
+}
+
+// changedKeys returns the paths of the entries in the mergeable maps, like
+// "services.minecraft", that were added, removed or changed from old to new.
+func changedKeys(old, new *Config) (added, removed, changed []string) {
+	diff := func(key string, old, new map[string]json.RawMessage) {
+		for name, b := range new {
+			if oldB, ok := old[name]; !ok {
+				added = append(added, key+"."+name)
+			} else if !bytes.Equal(oldB, b) {
+				changed = append(changed, key+"."+name)
+			}
+		}
+		for name := range old {
+			if _, ok := new[name]; !ok {
+				removed = append(removed, key+"."+name)
+			}
+		}
+	}
+	diff("commanders", old.Commanders, new.Commanders)
+	diff("streamers", old.Streamers, new.Streamers)
+	diff("services", old.Services, new.Services)
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return
+}
+
+// checkConfig reports all errors found in the config file without
+// constructing any service.
+func checkConfig(path string) []error {
+	src, err := readConfig(path)
+	if err != nil {
+		return []error{err}
+	}
+	errs := common.CheckConfig(Config{}, src.JSON, "")
+	for i, err := range errs {
+		var configErr *common.ConfigError
+		if errors.As(err, &configErr) {
+			errs[i] = src.Locate(configErr.Path, err)
+		}
+	}
+	return errs
+}
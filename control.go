@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+// controller implements common.Controller. Reloads are handed to the main
+// loop, so that they never run concurrently with those triggered by signals
+// or the config watcher.
+type controller struct {
+	reloadC chan chan error
+
+	mu           sync.Mutex
+	loadedAt     time.Time
+	lastReloadAt time.Time
+	lastErr      error
+}
+
+func newController() *controller {
+	return &controller{
+		reloadC:  make(chan chan error),
+		loadedAt: time.Now(),
+	}
+}
+
+// record records the outcome of a reload.
+func (c *controller) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastReloadAt = time.Now()
+	c.lastErr = err
+	if err == nil {
+		c.loadedAt = c.lastReloadAt
+	}
+}
+
+func (c *controller) Status() common.RuntimeStatus {
+	c.mu.Lock()
+	status := common.RuntimeStatus{
+		Version:      version,
+		LoadedAt:     c.loadedAt,
+		LastReloadAt: c.lastReloadAt,
+	}
+	if c.lastErr != nil {
+		status.LastReloadError = c.lastErr.Error()
+	}
+	c.mu.Unlock()
+
+	if t, ok := handler.Get().(*tree); ok {
+		status.Mounts = t.Mounts()
+	}
+	return status
+}
+
+func (c *controller) Reload() error {
+	done := make(chan error, 1)
+	c.reloadC <- done
+	return <-done
+}
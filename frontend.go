@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+const defaultListenAddr = ":8000"
+
+// HTTPConfig is the top-level "server" section of the config file.
+type HTTPConfig struct {
+	Listen          ListenList `json:"listen"`
+	ReadTimeout     string     `json:"read-timeout"`
+	WriteTimeout    string     `json:"write-timeout"`
+	IdleTimeout     string     `json:"idle-timeout"`
+	ShutdownTimeout string     `json:"shutdown-timeout"`
+	MaxHeaderBytes  int        `json:"max-header-bytes"`
+	TLS             TLSConfig  `json:"tls"`
+
+	AccessLog AccessLogConfig `json:"access-log"`
+	// ClientIPHeader is the header that trusted proxies put the address of
+	// the client in, if any, and TrustedProxies are the addresses or CIDR
+	// prefixes of those proxies, by default loopback addresses
+	ClientIPHeader string   `json:"client-ip-header"`
+	TrustedProxies []string `json:"trusted-proxies"`
+	// Index lists the services at "/"
+	Index bool `json:"index"`
+}
+
+type TLSConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Validate implements the common.Validator interface.
+func (c HTTPConfig) Validate() error {
+	durations := []struct{ key, value string }{
+		{"read-timeout", c.ReadTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"shutdown-timeout", c.ShutdownTimeout},
+	}
+	var errs []error
+	for _, d := range durations {
+		if err := common.CheckDuration(d.key, d.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.TLS.Enabled() && c.TLS.Cert == "" {
+		errs = append(errs, &common.ConfigError{Path: "tls.cert", Err: common.ErrMissing})
+	}
+	if c.TLS.Enabled() && c.TLS.Key == "" {
+		errs = append(errs, &common.ConfigError{Path: "tls.key", Err: common.ErrMissing})
+	}
+	if err := common.CheckPrefixes("trusted-proxies", c.TrustedProxies); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TrustedProxies != nil && c.ClientIPHeader == "" {
+		errs = append(errs, &common.ConfigError{Path: "client-ip-header", Err: errors.New("required with trusted-proxies")})
+	}
+	return errors.Join(errs...)
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.Cert != "" || c.Key != ""
+}
+
+type ListenConfig struct {
+	// Address is one of "host:port", "unix:/path/to/socket", "systemd" for
+	// all sockets passed by systemd, or "systemd:name" for those with the
+	// given FileDescriptorName.
+	Address string `json:"address"`
+
+	// Permissions of Unix domain sockets
+	Mode  FileMode `json:"mode"`
+	Owner string   `json:"owner"`
+	Group string   `json:"group"`
+
+	// ProxyProtocol requires connections to start with a PROXY protocol
+	// header, which tells the real address of the client
+	ProxyProtocol bool `json:"proxy-protocol"`
+}
+
+// UnmarshalJSON accepts either an address string or a full object.
+func (c *ListenConfig) UnmarshalJSON(b []byte) error {
+	var addr string
+	if err := json.Unmarshal(b, &addr); err == nil {
+		*c = ListenConfig{Address: addr}
+		return nil
+	}
+	type listenConfig ListenConfig
+	return json.Unmarshal(b, (*listenConfig)(c))
+}
+
+// ListenList accepts either a single listener or a list of listeners.
+type ListenList []ListenConfig
+
+func (l *ListenList) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, (*[]ListenConfig)(l))
+	}
+	var c ListenConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*l = ListenList{c}
+	return nil
+}
+
+// certStore holds the TLS certificate so that it can be replaced on reload
+// without restarting the listeners.
+type certStore struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+func loadCert(config TLSConfig) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+func (s *certStore) Load(config TLSConfig) error {
+	cert, err := loadCert(config)
+	if err != nil {
+		return err
+	}
+	s.cert.Store(cert)
+	return nil
+}
+
+func (s *certStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// frontend owns the HTTP listeners and the settings they were created with.
+type frontend struct {
+	config    HTTPConfig
+	server    *http.Server
+	handler   http.Handler
+	listeners []net.Listener
+	certs     *certStore
+	access    atomic.Pointer[accessLog]
+}
+
+func newFrontend(config HTTPConfig, h http.Handler) (*frontend, error) {
+	if len(config.Listen) == 0 {
+		config.Listen = ListenList{{Address: defaultListenAddr}}
+	}
+	f := &frontend{
+		config:  config,
+		handler: h,
+		server: &http.Server{
+			ReadTimeout:    common.ParseDurationDefault(config.ReadTimeout, 10*time.Second),
+			WriteTimeout:   common.ParseDurationDefault(config.WriteTimeout, 10*time.Second),
+			IdleTimeout:    common.ParseDurationDefault(config.IdleTimeout, 0),
+			MaxHeaderBytes: config.MaxHeaderBytes,
+		},
+	}
+	if config.TLS.Enabled() {
+		f.certs = new(certStore)
+		if err := f.certs.Load(config.TLS); err != nil {
+			return nil, err
+		}
+		f.server.TLSConfig = &tls.Config{GetCertificate: f.certs.GetCertificate}
+	}
+	access, err := newAccessLog(config)
+	if err != nil {
+		return nil, err
+	}
+	f.access.Store(access)
+	f.server.Handler = f
+
+	listeners, err := listenAll(config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	f.listeners = listeners
+	return f, nil
+}
+
+func (f *frontend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.access.Load().serve(f.handler, w, r)
+}
+
+// Serve serves on all listeners and returns when any of them fails, or once
+// all of them have stopped after Shutdown.
+func (f *frontend) Serve() error {
+	errC := make(chan error, len(f.listeners))
+	for _, ln := range f.listeners {
+		slog.Info("Listening", "addr", ln.Addr().String())
+		go func(ln net.Listener) {
+			if f.certs != nil {
+				errC <- f.server.ServeTLS(ln, "", "")
+			} else {
+				errC <- f.server.Serve(ln)
+			}
+		}(ln)
+	}
+	for range f.listeners {
+		if err := <-errC; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload prepares the parts of config that can be changed at runtime, and
+// logs the ones that need a restart to take effect. Nothing changes until
+// the returned function is called, so that it can be applied together with
+// the services.
+func (f *frontend) Reload(config HTTPConfig) (apply func(), err error) {
+	if len(config.Listen) == 0 {
+		config.Listen = ListenList{{Address: defaultListenAddr}}
+	}
+	access, err := newAccessLog(config)
+	if err != nil {
+		return nil, err
+	}
+	var cert *tls.Certificate
+	if config.TLS.Enabled() != f.config.TLS.Enabled() {
+		slog.Warn("Enabling or disabling TLS requires a restart")
+		config.TLS = f.config.TLS
+	} else if config.TLS.Enabled() {
+		if cert, err = loadCert(config.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	old, new := f.config, config
+	for _, c := range []*HTTPConfig{&old, &new} {
+		c.TLS = TLSConfig{}
+		c.ShutdownTimeout = ""
+		c.AccessLog = AccessLogConfig{}
+		c.TrustedProxies, c.ClientIPHeader = nil, ""
+	}
+	if !reflect.DeepEqual(old, new) {
+		slog.Warn("Changes to listeners and timeouts require a restart")
+	}
+	return func() {
+		if cert != nil {
+			f.certs.cert.Store(cert)
+		}
+		f.config.TLS = config.TLS
+		f.config.ShutdownTimeout = config.ShutdownTimeout
+		f.config.AccessLog = config.AccessLog
+		f.config.TrustedProxies, f.config.ClientIPHeader = config.TrustedProxies, config.ClientIPHeader
+		f.access.Store(access)
+	}, nil
+}
+
+// Shutdown stops accepting new connections and waits for requests in flight
+// until the configured shutdown timeout expires, after which the remaining
+// connections are closed.
+func (f *frontend) Shutdown() error {
+	timeout := common.ParseDurationDefault(f.config.ShutdownTimeout, 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := f.server.Shutdown(ctx)
+	if err != nil {
+		f.server.Close()
+	}
+	return err
+}
@@ -4,6 +4,9 @@ go 1.25
 
 require (
 	github.com/docker/docker v28.3.3+incompatible
+	go.yaml.in/yaml/v3 v3.0.3
+	golang.org/x/crypto v0.29.0
+	golang.org/x/time v0.3.0
 	sigs.k8s.io/yaml v1.6.0
 )
 
@@ -35,6 +38,5 @@ require (
 	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/net v0.31.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
 	gotest.tools/v3 v3.4.0 // indirect
 )
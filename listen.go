@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// FileMode accepts either a YAML octal number (0660) or an octal string
+// ("0660").
+type FileMode os.FileMode
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return errors.New("invalid file mode")
+		}
+		*m = FileMode(mode)
+		return nil
+	}
+	var mode uint32
+	if err := json.Unmarshal(b, &mode); err != nil {
+		return err
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+type systemdListener struct {
+	name string
+	net.Listener
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdSockets   []systemdListener
+	systemdSocketErr error
+)
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// or nil if there are none. See sd_listen_fds(3).
+func systemdListeners() ([]systemdListener, error) {
+	systemdOnce.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			systemdSocketErr = fmt.Errorf("invalid LISTEN_FDS: %w", err)
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := range n {
+			// The first passed file descriptor is always 3
+			fd := 3 + i
+			syscall.CloseOnExec(fd)
+			name := "unknown"
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(fd), name)
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				systemdSocketErr = fmt.Errorf("systemd socket %d (%s): %w", fd, name, err)
+				return
+			}
+			systemdSockets = append(systemdSockets, systemdListener{name: name, Listener: ln})
+		}
+	})
+	return systemdSockets, systemdSocketErr
+}
+
+func lookupUid(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGid(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+func listenUnix(c ListenConfig, path string) (net.Listener, error) {
+	// Remove a stale socket left behind by an unclean exit, but not one that
+	// another process is still listening on
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == os.ModeSocket {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if c.Mode != 0 {
+		if err := os.Chmod(path, os.FileMode(c.Mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if c.Owner != "" || c.Group != "" {
+		uid, gid := -1, -1
+		if c.Owner != "" {
+			if uid, err = lookupUid(c.Owner); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+		if c.Group != "" {
+			if gid, err = lookupGid(c.Group); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+func listen(c ListenConfig) ([]net.Listener, error) {
+	if name, ok := strings.CutPrefix(c.Address, "systemd"); ok && (name == "" || name[0] == ':') {
+		name = strings.TrimPrefix(name, ":")
+		sockets, err := systemdListeners()
+		if err != nil {
+			return nil, err
+		}
+		var listeners []net.Listener
+		for _, s := range sockets {
+			if name == "" || s.name == name {
+				listeners = append(listeners, s.Listener)
+			}
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+		}
+		return listeners, nil
+	}
+	if path, ok := strings.CutPrefix(c.Address, "unix:"); ok {
+		ln, err := listenUnix(c, path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+	ln, err := net.Listen("tcp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// listenAll opens all configured listeners. If systemd passed any sockets
+// and none of the configured listeners refer to them, the passed sockets are
+// used instead of the configured addresses, so that the same unit file works
+// with and without socket activation.
+func listenAll(configs ListenList) ([]net.Listener, error) {
+	sockets, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	usesSystemd := false
+	for _, c := range configs {
+		if c.Address == "systemd" || strings.HasPrefix(c.Address, "systemd:") {
+			usesSystemd = true
+		}
+	}
+	if len(sockets) > 0 && !usesSystemd {
+		slog.Info("Using sockets passed by systemd", "count", len(sockets))
+		configs = ListenList{{Address: "systemd"}}
+	}
+
+	var listeners []net.Listener
+	for _, c := range configs {
+		lns, err := listen(c)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", c.Address, err)
+		}
+		if c.ProxyProtocol {
+			for i, ln := range lns {
+				lns[i] = proxyListener{ln}
+			}
+		}
+		listeners = append(listeners, lns...)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+// LogConfig is the top-level "log" section of the config file.
+type LogConfig struct {
+	// Format is "text" (the default) or "json"
+	Format string `json:"format"`
+	// Level is the minimum level of messages from plugins without a
+	// log-level of their own, "info" by default
+	Level string `json:"level"`
+}
+
+// Validate implements the common.Validator interface.
+func (c LogConfig) Validate() error {
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return &common.ConfigError{Path: "format", Err: errors.New(`must be "text" or "json"`)}
+	}
+	if _, err := c.level(); err != nil {
+		return &common.ConfigError{Path: "level", Err: errors.New("invalid log level")}
+	}
+	return nil
+}
+
+func (c LogConfig) level() (slog.Level, error) {
+	var level slog.Level
+	if c.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(c.Level))
+	return level, err
+}
+
+// logFormat is the format that the log was set up with, which is also the
+// default format of the access log.
+var logFormat string
+
+// newLogHandler returns a handler writing all levels to w in format, or in
+// logFormat if empty.
+func newLogHandler(w io.Writer, format string) (slog.Handler, error) {
+	if format == "" {
+		format = logFormat
+	}
+	opts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	// $JOURNAL_STREAM is set by systemd v231+, and journald adds its own
+	// timestamps
+	if _, ok := os.LookupEnv("JOURNAL_STREAM"); ok {
+		opts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		}
+	}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// setupLogging applies config to the default logger. The format is only set
+// the first time, as loggers already handed out to plugins keep the handler.
+func setupLogging(config LogConfig, first bool) error {
+	level, err := config.level()
+	if err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+	if first {
+		h, err := newLogHandler(os.Stderr, config.Format)
+		if err != nil {
+			return err
+		}
+		logFormat = config.Format
+		common.SetLogHandler(h)
+	} else if config.Format != logFormat {
+		slog.Warn("Changing the log format requires a restart")
+	}
+	common.LogLevel().Set(level)
+	return nil
+}
@@ -2,114 +2,222 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/iBug/uniAPI/common"
 	_ "github.com/iBug/uniAPI/plugins"
 	"github.com/iBug/uniAPI/server"
-	"sigs.k8s.io/yaml"
 )
 
-type Config struct {
-	Services server.ServiceSet `json:"services"`
-}
-
 var (
 	handler server.ReloadableHandler
 	version string
 )
 
-func logRequest(r *http.Request) {
-	remoteAddr := r.Header.Get("CF-Connecting-IP")
-	if remoteAddr == "" {
-		remoteAddr = "(local)"
-	}
-	log.Printf("%s %q from %s\n", r.Method, r.URL.Path, remoteAddr)
-}
-
-func loadConfig(path string) error {
-	if path == "" {
-		var err error
-		path, err = common.DefaultConfigPath()
-		if err != nil {
-			return err
-		}
-
-	}
-	b, err := os.ReadFile(path)
+// loadServices constructs and starts the services, reusing the unchanged
+// ones, then puts them into service in place of the previous ones.
+func loadServices(config *Config) error {
+	old, _ := handler.Get().(*tree)
+	s, err := newTree(config, old)
 	if err != nil {
 		return err
 	}
-
-	var config Config
-	err = yaml.Unmarshal(b, &config)
-	if err != nil {
+	// The new tree must be fully started before it replaces the old one,
+	// so that a failure here leaves the old tree in service.
+	if err := s.Start(); err != nil {
 		return err
 	}
-
-	s, err := server.NewServer(config.Services)
-	if err != nil {
-		return err
+	_, drain := handler.Swap(s)
+	if old != nil {
+		logChanges(old.config, config)
+		go func() {
+			drain()
+			if err := old.stopReplaced(s); err != nil {
+				slog.Error("Failed to stop old services", "err", err)
+			}
+			runtime.GC()
+		}()
 	}
-	handler.Set(s)
-	runtime.GC()
 	return nil
 }
 
+func logChanges(old, new *Config) {
+	added, removed, changed := changedKeys(old, new)
+	if len(added)+len(removed)+len(changed) == 0 {
+		slog.Info("No services changed")
+		return
+	}
+	for _, c := range []struct {
+		what string
+		keys []string
+	}{{"Added", added}, {"Removed", removed}, {"Changed", changed}} {
+		if len(c.keys) > 0 {
+			slog.Info(c.what, "services", strings.Join(c.keys, ", "))
+		}
+	}
+}
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
 func main() {
 	var (
-		listenAddr   string
-		configFile   string
-		printVersion bool
+		listenAddr      string
+		configFile      string
+		printVersion    bool
+		checkOnly       bool
+		watch           bool
+		shutdownTimeout time.Duration
 	)
-	flag.StringVar(&listenAddr, "l", ":8000", "listen address")
+	flag.StringVar(&listenAddr, "l", defaultListenAddr, "listen address, overrides server.listen in config")
 	flag.StringVar(&configFile, "c", "", "config file (default ~/.config/uniAPI.yml)")
 	flag.BoolVar(&printVersion, "v", false, "print version and exit")
+	flag.BoolVar(&checkOnly, "t", false, "check config and exit, same as the \"check\" command")
+	flag.BoolVar(&watch, "w", false, "reload automatically when the config files change")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "time to wait for requests in flight on shutdown, overrides server.shutdown-timeout in config")
 	flag.Parse()
 
-	// $JOURNAL_STREAM is set by systemd v231+
-	if _, ok := os.LookupEnv("JOURNAL_STREAM"); ok {
-		log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+	// Command-line flags take precedence over the config file
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	applyFlags := func(config *HTTPConfig) {
+		if setFlags["l"] {
+			config.Listen = ListenList{{Address: listenAddr}}
+		}
+		if setFlags["shutdown-timeout"] {
+			config.ShutdownTimeout = shutdownTimeout.String()
+		}
 	}
 
+	common.NewGaugeVec("uniapi_build_info", "Always 1, labelled with the version.", "version").Set(1, version)
+
 	if printVersion {
-		log.Printf("uniAPI version %s", version)
+		fmt.Printf("uniAPI version %s\n", version)
 		os.Exit(0)
 	}
 
-	if err := loadConfig(configFile); err != nil {
-		log.Fatal(err)
+	if checkOnly || flag.Arg(0) == "check" {
+		errs := checkConfig(configFile)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "%d error(s) found\n", len(errs))
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Config OK")
+		os.Exit(0)
 	}
 
-	// Reload config on SIGHUP
-	signalC := make(chan os.Signal, 1)
-	signal.Notify(signalC, syscall.SIGHUP)
-	go func() {
-		for range signalC {
-			if err := loadConfig(configFile); err != nil {
-				log.Printf("Error reloading config: %v", err)
-			} else {
-				log.Printf("Config reloaded!")
-			}
-		}
-	}()
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fatal(err)
+	}
+	if err := setupLogging(config.Log, true); err != nil {
+		fatal(err)
+	}
+	applyFlags(&config.Server)
+	if err := loadServices(config); err != nil {
+		fatal(err)
+	}
 
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logRequest(r)
 		w.Header().Set("X-Robots-Tag", "noindex")
 		handler.ServeHTTP(w, r)
 	})
-	s := &http.Server{
-		Addr:         listenAddr,
-		Handler:      h,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	f, err := newFrontend(config.Server, h)
+	if err != nil {
+		fatal(err)
+	}
+	go func() {
+		if err := f.Serve(); err != nil {
+			fatal(err)
+		}
+	}()
+
+	var watcher *configWatcher
+	reloadC := make(chan struct{}, 1)
+	if watch {
+		watcher, err = newConfigWatcher()
+		if err == nil {
+			err = watcher.Watch(config.source)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		go func() {
+			if err := watcher.Run(reloadC); err != nil {
+				slog.Error("Stopped watching config files", "err", err)
+			}
+		}()
+	}
+
+	ctl := newController()
+	common.SetController(ctl)
+	reload := func() error {
+		config, err := loadConfig(configFile)
+		if err == nil && watcher != nil {
+			// Included files may have been added or removed
+			err = watcher.Watch(config.source)
+		}
+		if err == nil {
+			err = setupLogging(config.Log, false)
+		}
+		// The server settings only take effect if the services load too
+		var applyServer func()
+		if err == nil {
+			applyFlags(&config.Server)
+			applyServer, err = f.Reload(config.Server)
+		}
+		if err == nil {
+			err = loadServices(config)
+		}
+		if err == nil {
+			applyServer()
+		}
+		if err != nil {
+			slog.Error("Failed to reload config", "err", err)
+		} else {
+			slog.Info("Config reloaded")
+		}
+		ctl.record(err)
+		return err
+	}
+
+	// Reload config on SIGHUP, shut down gracefully on SIGINT and SIGTERM
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case <-reloadC:
+			slog.Info("Config files changed, reloading")
+			reload()
+		case done := <-ctl.reloadC:
+			slog.Info("Reload requested")
+			done <- reload()
+		case sig := <-signalC:
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			slog.Info("Shutting down", "signal", sig.String())
+			if err := f.Shutdown(); err != nil {
+				slog.Error("Failed to drain requests", "err", err)
+			}
+			if err := common.Deactivate(handler.Get()); err != nil {
+				slog.Error("Failed to stop services", "err", err)
+			}
+			return
+		}
 	}
-	log.Fatal(s.ListenAndServe())
 }
@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+type Config struct {
+	Tokens []string `json:"tokens"`
+}
+
+func (c Config) Validate() error {
+	if len(c.Tokens) == 0 {
+		return &common.ConfigError{Path: "tokens", Err: errors.New("at least one token is required")}
+	}
+	return nil
+}
+
+type Plugins struct {
+	Services   []string `json:"services"`
+	Commanders []string `json:"commanders"`
+	Streamers  []string `json:"streamers"`
+}
+
+type Status struct {
+	common.RuntimeStatus
+	Plugins Plugins `json:"plugins"`
+}
+
+type Service struct {
+	mux    *http.ServeMux
+	tokens []string
+}
+
+func NewService(rawConfig json.RawMessage) (common.Service, error) {
+	var config Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Service{
+		mux:    http.NewServeMux(),
+		tokens: config.Tokens,
+	}
+	s.mux.HandleFunc("GET /{$}", s.handleStatus)
+	s.mux.HandleFunc("GET /mounts", s.handleMounts)
+	s.mux.HandleFunc("GET /plugins", s.handlePlugins)
+	s.mux.HandleFunc("POST /reload", s.handleReload)
+	return s, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func plugins() Plugins {
+	return Plugins{
+		Services:   common.Services.Names(),
+		Commanders: common.Commanders.Names(),
+		Streamers:  common.Streamers.Names(),
+	}
+}
+
+// Describe implements the common.Describer interface.
+func (s *Service) Describe() string {
+	return "Status and control of the running instance"
+}
+
+// ServeHTTP implements the http.Handler interface. A token is required for
+// local requests too, even if token-protected is set to allow them.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !common.ValidateToken(r.Header.Get("Authorization"), s.tokens) {
+		common.Error(w, r, http.StatusForbidden, "")
+		return
+	}
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Service) controller(w http.ResponseWriter, r *http.Request) common.Controller {
+	c := common.GetController()
+	if c == nil {
+		common.Error(w, r, http.StatusServiceUnavailable, "not available")
+	}
+	return c
+}
+
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c := s.controller(w, r)
+	if c == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, Status{c.Status(), plugins()})
+}
+
+func (s *Service) handleMounts(w http.ResponseWriter, r *http.Request) {
+	c := s.controller(w, r)
+	if c == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Status().Mounts)
+}
+
+func (s *Service) handlePlugins(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, plugins())
+}
+
+func (s *Service) handleReload(w http.ResponseWriter, r *http.Request) {
+	c := s.controller(w, r)
+	if c == nil {
+		return
+	}
+	if err := c.Reload(); err != nil {
+		common.Error(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func init() {
+	common.Services.Register("admin", NewService)
+	common.Services.RegisterConfig("admin", Config{})
+}
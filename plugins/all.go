@@ -1,13 +1,19 @@
 package plugins
 
 import (
+	_ "github.com/iBug/uniAPI/plugins/admin"
+	_ "github.com/iBug/uniAPI/plugins/auth"
+	_ "github.com/iBug/uniAPI/plugins/cache"
 	_ "github.com/iBug/uniAPI/plugins/csgo"
 	_ "github.com/iBug/uniAPI/plugins/docker"
 	_ "github.com/iBug/uniAPI/plugins/factorio"
 	_ "github.com/iBug/uniAPI/plugins/github"
 	_ "github.com/iBug/uniAPI/plugins/ibugauth"
+	_ "github.com/iBug/uniAPI/plugins/jwt"
+	_ "github.com/iBug/uniAPI/plugins/metrics"
 	_ "github.com/iBug/uniAPI/plugins/minecraft"
 	_ "github.com/iBug/uniAPI/plugins/palworld"
+	_ "github.com/iBug/uniAPI/plugins/ratelimit"
 	_ "github.com/iBug/uniAPI/plugins/rcon"
 	_ "github.com/iBug/uniAPI/plugins/robotstxt"
 	_ "github.com/iBug/uniAPI/plugins/teamspeak"
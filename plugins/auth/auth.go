@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+type Config struct {
+	common.AuthConfig
+	Service json.RawMessage `json:"service" registry:"service"`
+}
+
+type Service struct {
+	next    common.Service
+	handler http.Handler
+}
+
+// Unwrap implements the common.Wrapper interface.
+func (s *Service) Unwrap() common.Service {
+	return s.next
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start implements the common.Activator interface.
+func (s *Service) Start() error {
+	return common.Activate(s.next)
+}
+
+// Stop implements the common.Activator interface.
+func (s *Service) Stop() error {
+	return common.Deactivate(s.next)
+}
+
+func NewService(rawConfig json.RawMessage) (common.Service, error) {
+	var config Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	auth, err := common.NewAuthenticator(config.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	next, err := common.Services.NewFromConfig(config.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		next:    next,
+		handler: auth.Wrap(next),
+	}, nil
+}
+
+func init() {
+	common.Services.Register("auth", NewService)
+	common.Services.RegisterConfig("auth", Config{})
+}
@@ -0,0 +1,351 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+var cacheRequests = common.NewCounterVec("uniapi_cache_requests_total",
+	"GET requests to cache services by service and result.", "service", "result")
+
+type Config struct {
+	// TTL is how long responses are served from the cache, 5s by default
+	TTL string `json:"ttl"`
+	// Stale is how long after TTL a response may still be served when
+	// refreshing it fails
+	Stale      string `json:"stale"`
+	MaxEntries int    `json:"max-entries"`
+
+	Service json.RawMessage `json:"service" registry:"service"`
+}
+
+// Validate implements the common.Validator interface.
+func (c Config) Validate() error {
+	var errs []error
+	if err := common.CheckDuration("ttl", c.TTL); err != nil {
+		errs = append(errs, err)
+	}
+	if err := common.CheckDuration("stale", c.Stale); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxEntries < 0 {
+		errs = append(errs, &common.ConfigError{Path: "max-entries", Err: errors.New("must not be negative")})
+	}
+	return errors.Join(errs...)
+}
+
+// response is a response recorded from the wrapped service.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+	etag   string
+	stored time.Time
+	// vary are the request headers listed in Vary, and key is the key the
+	// response is cached under, or "" if it must not be shared
+	vary []string
+	key  string
+}
+
+// cacheKey returns the key of responses to r that vary by the request
+// headers in vary.
+func cacheKey(r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.RequestURI())
+	for _, name := range vary {
+		b.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// varyHeaders returns the canonical names of the headers listed in the Vary
+// header of h, sorted, or "*".
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// shareable reports whether a response with header h may be served to other
+// clients: it must not set cookies, be private or no-store, or vary by more
+// than request headers.
+func shareable(h http.Header) bool {
+	if len(h.Values("Set-Cookie")) > 0 || slices.Contains(varyHeaders(h), "*") {
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive, _, _ = strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(directive) {
+			case "private", "no-store":
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// recorder records a response in full.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// call is a request to the wrapped service that others may wait for.
+type call struct {
+	done chan struct{}
+	resp *response
+}
+
+type Service struct {
+	next       common.Service
+	ttl        time.Duration
+	stale      time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*response
+	calls   map[string]*call
+	// varies maps the keys of requests without their headers to the
+	// headers that the last cached response varied by
+	varies map[string][]string
+}
+
+func NewService(rawConfig json.RawMessage) (common.Service, error) {
+	var config Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	next, err := common.Services.NewFromConfig(config.Service)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		next:       next,
+		ttl:        common.ParseDurationDefault(config.TTL, 5*time.Second),
+		stale:      common.ParseDurationDefault(config.Stale, 0),
+		maxEntries: config.MaxEntries,
+		entries:    make(map[string]*response),
+		calls:      make(map[string]*call),
+		varies:     make(map[string][]string),
+	}
+	if s.maxEntries == 0 {
+		s.maxEntries = 1000
+	}
+	return s, nil
+}
+
+// store caches resp under key, making room if needed. It must be called
+// with mu held.
+func (s *Service) store(key string, resp *response) {
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= s.maxEntries {
+		for k, e := range s.entries {
+			if time.Since(e.stored) >= s.ttl+s.stale {
+				delete(s.entries, k)
+			}
+		}
+		// Still full, so drop any entry
+		for k := range s.entries {
+			if len(s.entries) < s.maxEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+		if len(s.varies) >= s.maxEntries {
+			// Only costs a miss for responses with Vary
+			clear(s.varies)
+		}
+	}
+	s.entries[key] = resp
+}
+
+// fetch gets the response for key from the wrapped service, or waits for a
+// fetch already in progress, in which case shared is true. Only successful
+// responses that may be shared are cached.
+func (s *Service) fetch(key string, r *http.Request) (resp *response, shared bool) {
+	s.mu.Lock()
+	if c, ok := s.calls[key]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return c.resp, true
+	}
+	c := &call{done: make(chan struct{})}
+	s.calls[key] = c
+	s.mu.Unlock()
+
+	defer func() {
+		if c.resp == nil {
+			// The wrapped service panicked, so waiters get an error
+			c.resp = &response{status: http.StatusInternalServerError, header: make(http.Header)}
+		}
+		s.mu.Lock()
+		delete(s.calls, key)
+		if c.resp.status == http.StatusOK && c.resp.key != "" {
+			s.store(c.resp.key, c.resp)
+			if base := cacheKey(r, nil); c.resp.vary != nil {
+				s.varies[base] = c.resp.vary
+			} else {
+				delete(s.varies, base)
+			}
+		}
+		s.mu.Unlock()
+		close(c.done)
+	}()
+	rec := &recorder{header: make(http.Header)}
+	s.next.ServeHTTP(rec, r)
+	resp = &response{
+		status: rec.status,
+		header: rec.header,
+		body:   rec.body.Bytes(),
+		stored: time.Now(),
+	}
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	resp.vary = varyHeaders(resp.header)
+	if shareable(resp.header) {
+		resp.key = cacheKey(r, resp.vary)
+	}
+	if resp.status == http.StatusOK {
+		resp.etag = resp.header.Get("ETag")
+		if resp.etag == "" {
+			sum := sha256.Sum256(resp.body)
+			resp.etag = `"` + hex.EncodeToString(sum[:8]) + `"`
+		}
+	}
+	c.resp = resp
+	return resp, false
+}
+
+// etagMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison of RFC 9110.
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) write(w http.ResponseWriter, r *http.Request, resp *response, result string) {
+	cacheRequests.Inc(common.MountPath(r.Context()), result)
+	h := w.Header()
+	for k, v := range resp.header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set("X-Cache", result)
+	if result != "MISS" {
+		h.Set("Age", strconv.Itoa(int(time.Since(resp.stored).Seconds())))
+	}
+	if resp.etag != "" {
+		h.Set("ETag", resp.etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, resp.etag) {
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// Unwrap implements the common.Wrapper interface.
+func (s *Service) Unwrap() common.Service {
+	return s.next
+}
+
+// ServeHTTP implements the http.Handler interface. GET requests are cached
+// by host, path and query, and the request headers listed in Vary, and other
+// requests are passed through.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	// The response may be waited for by others
+	req := r.Clone(context.WithoutCancel(r.Context()))
+
+	s.mu.Lock()
+	key := cacheKey(r, s.varies[cacheKey(r, nil)])
+	e := s.entries[key]
+	s.mu.Unlock()
+	var age time.Duration
+	if e != nil {
+		age = time.Since(e.stored)
+	}
+	if e != nil && age < s.ttl {
+		s.write(w, r, e, "HIT")
+		return
+	}
+	resp, shared := s.fetch(key, req)
+	if shared && resp.key == "" {
+		// Meant for the client that made the request only
+		resp, _ = s.fetch(rand.Text(), req)
+	} else if shared && resp.key != cacheKey(r, resp.vary) {
+		// Another variant than this request asks for
+		resp, _ = s.fetch(cacheKey(r, resp.vary), req)
+	}
+	if resp.status >= http.StatusInternalServerError && e != nil && age < s.ttl+s.stale {
+		// The backend failed, so the last good response is better
+		s.write(w, r, e, "STALE")
+		return
+	}
+	s.write(w, r, resp, "MISS")
+}
+
+// Start implements the common.Activator interface.
+func (s *Service) Start() error {
+	return common.Activate(s.next)
+}
+
+// Stop implements the common.Activator interface.
+func (s *Service) Stop() error {
+	return common.Deactivate(s.next)
+}
+
+func init() {
+	common.Services.Register("cache", NewService)
+	common.Services.RegisterConfig("cache", Config{})
+}
@@ -3,10 +3,11 @@ package csgo
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
@@ -69,11 +70,13 @@ type Client struct {
 	SilentFunc func() bool
 
 	commander common.Commander
+	log       *slog.Logger
 
 	savedStatus  Status
 	localState   LocalState
 	localStateMu sync.Mutex
 	logChan      chan<- string
+	logChanMu    sync.RWMutex
 }
 
 func NewClient(config Config) (*Client, error) {
@@ -85,10 +88,9 @@ func NewClient(config Config) (*Client, error) {
 		Api:       config.Online.Api,
 		CacheTime: 10 * time.Second,
 		commander: commander,
+		log:       common.Logger(),
 	}
 
-	c.startLogWatcher()
-
 	if config.Online.DisableFile != "" {
 		c.SilentFunc = func() bool {
 			_, err := os.Stat(config.Online.DisableFile)
@@ -107,17 +109,23 @@ func (s *Status) ParseGameMode() string {
 	return "unknown"
 }
 
-func (c *Client) GetStatus() (Status, error) {
-	msg, err := c.commander.Execute("status; cvarlist game_")
+// GetStatus queries the server for its status. Failures are logged with
+// ctx, so that they can be told apart by request.
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
+	msg, err := common.ExecuteContext(ctx, c.commander, "status; cvarlist game_")
 	retries := 0
 	for err != nil {
 		retries++
-		log.Printf("csgo.GetStatus rcon error %d: %v", retries, err)
-		if retries >= 3 {
+		c.log.WarnContext(ctx, "RCON command failed", "retries", retries, "err", err)
+		if retries >= 3 || ctx.Err() != nil {
 			return Status{}, fmt.Errorf("csgo.GetStatus error: %w", err)
 		}
-		time.Sleep(1 * time.Second)
-		msg, err = c.commander.Execute("cvarlist game_; status")
+		select {
+		case <-time.After(1 * time.Second):
+		case <-ctx.Done():
+			return Status{}, fmt.Errorf("csgo.GetStatus error: %w", ctx.Err())
+		}
+		msg, err = common.ExecuteContext(ctx, c.commander, "cvarlist game_; status")
 	}
 
 	status := Status{Players: make([]string, 0, 10)}
@@ -180,11 +188,16 @@ func (c *Client) GetStatus() (Status, error) {
 	return status, nil
 }
 
-func (c *Client) GetCachedStatus() (Status, error) {
+func (c *Client) GetCachedStatus(ctx context.Context) (Status, error) {
 	if time.Since(c.savedStatus.Time) < c.CacheTime {
 		return c.savedStatus, nil
 	}
-	return c.GetStatus()
+	return c.GetStatus(ctx)
+}
+
+// Describe implements the common.Describer interface.
+func (c *Client) Describe() string {
+	return "Counter-Strike server status and scores"
 }
 
 // ServeHTTP implements the http.Handler interface.
@@ -197,14 +210,19 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	status, err := c.GetStatus()
+	status, err := c.GetStatus(r.Context())
+	service := common.MountPath(r.Context())
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"status": err.Error()})
+		common.GameUp.Set(0, service)
+		c.log.ErrorContext(r.Context(), "Failed to get status", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 
+	common.GameUp.Set(1, service)
+	common.GamePlayers.Set(float64(status.PlayerCount), service)
+	common.GameBots.Set(float64(status.BotCount), service)
+
 	w.Header().Set("Cache-Control", "public, max-age=5")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(status)
@@ -236,7 +254,7 @@ func (c *Client) SendOnlineNotice(action, name string, count int) error {
 	retry := 0
 	for err != nil {
 		retry++
-		log.Printf("SendOnlineNotice error %d: %v\n", retry, err)
+		c.log.Warn("Failed to send online notice", "retries", retry, "err", err)
 		if retry >= 3 {
 			return err
 		}
@@ -252,10 +270,10 @@ func (c *Client) handleLogMessage(s string) {
 	// Check online
 	matches := ReConnected.FindStringSubmatch(s)
 	if len(matches) >= 5 && matches[3] != "BOT" {
-		log.Printf("CSGO Online: %v connected\n", matches[1])
-		status, err := c.GetStatus()
+		c.log.Info("Player connected", "player", matches[1])
+		status, err := c.GetStatus(context.Background())
 		if err != nil {
-			log.Print(err)
+			c.log.Error("Failed to get status", "err", err)
 			return
 		}
 		if status.PlayerCount < 1 || status.PlayerCount > 2 {
@@ -263,7 +281,7 @@ func (c *Client) handleLogMessage(s string) {
 		}
 		err = c.SendOnlineNotice("goonline", matches[1], status.PlayerCount)
 		if err != nil {
-			log.Print(err)
+			c.log.Error("Failed to send online notice", "err", err)
 		}
 		return
 	}
@@ -271,7 +289,7 @@ func (c *Client) handleLogMessage(s string) {
 	// Check offline
 	matches = ReDisconnected.FindStringSubmatch(s)
 	if len(matches) >= 5 {
-		log.Printf("CSGO Online: %v disconnected\n", matches[1])
+		c.log.Info("Player disconnected", "player", matches[1])
 		c.localStateMu.Lock()
 		c.localState.RemovePlayer(matches[1])
 		c.localStateMu.Unlock()
@@ -279,9 +297,9 @@ func (c *Client) handleLogMessage(s string) {
 			return
 		}
 
-		status, err := c.GetStatus()
+		status, err := c.GetStatus(context.Background())
 		if err != nil {
-			log.Print(err)
+			c.log.Error("Failed to get status", "err", err)
 			return
 		}
 		if status.PlayerCount > 0 {
@@ -289,7 +307,7 @@ func (c *Client) handleLogMessage(s string) {
 		}
 		err = c.SendOnlineNotice("gooffline", matches[1], status.PlayerCount)
 		if err != nil {
-			log.Print(err)
+			c.log.Error("Failed to send online notice", "err", err)
 		}
 		return
 	}
@@ -304,7 +322,7 @@ func (c *Client) handleLogMessage(s string) {
 			c.localState.JoinTeam("BOT", oldTeam, newTeam)
 			return
 		}
-		log.Printf("CSGO Online: %v joins team %v\n", player, newTeam)
+		c.log.Info("Player joined team", "player", player, "team", newTeam)
 		c.localState.JoinTeam(player, oldTeam, newTeam)
 	}
 
@@ -341,6 +359,8 @@ func (c *Client) handleLogMessage(s string) {
 }
 
 func (c *Client) handleLogHTTP(r *http.Request) {
+	c.logChanMu.RLock()
+	defer c.logChanMu.RUnlock()
 	if c.logChan == nil {
 		return
 	}
@@ -380,10 +400,36 @@ func (c *Client) startLogWatcher() error {
 			}
 		}
 	}(ch)
+	c.logChanMu.Lock()
 	c.logChan = ch
+	c.logChanMu.Unlock()
+	return nil
+}
+
+func (c *Client) stopLogWatcher() error {
+	c.logChanMu.Lock()
+	defer c.logChanMu.Unlock()
+	if c.logChan != nil {
+		close(c.logChan)
+		c.logChan = nil
+	}
 	return nil
 }
 
+// Start implements the common.Activator interface.
+func (c *Client) Start() error {
+	if err := common.Activate(c.commander); err != nil {
+		return err
+	}
+	return c.startLogWatcher()
+}
+
+// Stop implements the common.Activator interface.
+func (c *Client) Stop() error {
+	c.stopLogWatcher()
+	return common.Deactivate(c.commander)
+}
+
 func NewCsgoService(rawConfig json.RawMessage) (common.Service, error) {
 	config := Config{}
 	err := json.Unmarshal(rawConfig, &config)
@@ -395,4 +441,5 @@ func NewCsgoService(rawConfig json.RawMessage) (common.Service, error) {
 
 func init() {
 	common.Services.Register("csgo", NewCsgoService)
+	common.Services.RegisterConfig("csgo", Config{})
 }
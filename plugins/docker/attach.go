@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -14,14 +15,25 @@ import (
 	"github.com/iBug/uniAPI/common"
 )
 
+var attachFailures = common.NewCounterVec("uniapi_docker_attach_failures_total",
+	"Failed attempts to attach to a container by container name.", "container")
+
 type Attacher struct {
 	docker    *client.Client
 	container string
 	timeout   time.Duration
+	log       *slog.Logger
 }
 
+// Execute implements the common.Commander interface.
 func (c *Attacher) Execute(cmd string) (string, error) {
-	ctx := context.Background()
+	return c.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext implements the common.ContextCommander interface. The
+// output is whatever the container writes until it has been quiet for the
+// timeout, or until ctx is done.
+func (c *Attacher) ExecuteContext(ctx context.Context, cmd string) (string, error) {
 	stream, err := c.docker.ContainerAttach(ctx, c.container, container.AttachOptions{
 		Stream: true,
 		Stdin:  true,
@@ -29,25 +41,43 @@ func (c *Attacher) Execute(cmd string) (string, error) {
 		Stderr: false,
 	})
 	if err != nil {
+		attachFailures.Inc(c.container)
+		c.log.DebugContext(ctx, "Failed to attach to container", "container", c.container, "err", err)
 		return "", err
 	}
 	defer stream.Close()
 	reader := demuxStream(stream.Reader, hasTty(c.docker, ctx, c.container), false)
+	stop := context.AfterFunc(ctx, func() { stream.Conn.SetDeadline(time.Now()) })
+	defer stop()
 
 	if !strings.HasSuffix(cmd, "\n") {
 		cmd += "\n"
 	}
 
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	stream.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
 	_, err = stream.Conn.Write([]byte(cmd))
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
 		return "", fmt.Errorf("write to container %s: %w", c.container, err)
 	}
 
 	builder := new(strings.Builder)
 	buf := make([]byte, 4096)
 	for {
-		stream.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		// The deadline set when ctx is done must not be pushed back
+		deadline := time.Now().Add(c.timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		stream.Conn.SetReadDeadline(deadline)
+		if ctx.Err() != nil {
+			break
+		}
 		n, err := reader.Read(buf)
 		builder.Write(buf[:n])
 		if errors.Is(err, os.ErrDeadlineExceeded) {
@@ -56,9 +86,22 @@ func (c *Attacher) Execute(cmd string) (string, error) {
 			return "", fmt.Errorf("read from container %s: %w", c.container, err)
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	return builder.String(), nil
 }
 
+// Start implements the common.Activator interface.
+func (c *Attacher) Start() error {
+	return nil
+}
+
+// Stop implements the common.Activator interface.
+func (c *Attacher) Stop() error {
+	return c.docker.Close()
+}
+
 func NewAttacher(rawConfig json.RawMessage) (*Attacher, error) {
 	config := BaseConfig{}
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
@@ -73,6 +116,7 @@ func NewAttacher(rawConfig json.RawMessage) (*Attacher, error) {
 		docker:    docker,
 		container: config.Container,
 		timeout:   config.Timeout,
+		log:       common.Logger(),
 	}, nil
 }
 
@@ -87,4 +131,6 @@ func NewAttacherCommander(rawConfig json.RawMessage) (common.Commander, error) {
 func init() {
 	common.Streamers.Register("docker.stream", NewAttacherStreamer)
 	common.Commanders.Register("docker.attachexec", NewAttacherCommander)
+	common.Streamers.RegisterConfig("docker.stream", BaseConfig{})
+	common.Commanders.RegisterConfig("docker.attachexec", BaseConfig{})
 }
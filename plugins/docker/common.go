@@ -2,11 +2,13 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/iBug/uniAPI/common"
 )
 
 type BaseConfig struct {
@@ -15,6 +17,22 @@ type BaseConfig struct {
 	Timeout   time.Duration `json:"timeout"`
 }
 
+// Validate implements the common.Validator interface.
+func (c BaseConfig) Validate() error {
+	if c.Container == "" {
+		return &common.ConfigError{Path: "container", Err: common.ErrMissing}
+	}
+	if c.Host != "" {
+		if _, err := client.ParseHostURL(c.Host); err != nil {
+			return &common.ConfigError{Path: "host", Err: errors.New("invalid Docker host")}
+		}
+	}
+	if c.Timeout < 0 {
+		return &common.ConfigError{Path: "timeout", Err: errors.New("negative timeout")}
+	}
+	return nil
+}
+
 func DockerClient(config BaseConfig) (*client.Client, error) {
 	return client.NewClientWithOpts(
 		client.WithHost(config.Host),
@@ -36,10 +54,15 @@ func demuxStream(r io.Reader, tty bool, stderr bool) io.Reader {
 		return r
 	}
 	pipeR, pipeW := io.Pipe()
-	if stderr {
-		go stdcopy.StdCopy(io.Discard, pipeW, r)
-	} else {
-		go stdcopy.StdCopy(pipeW, io.Discard, r)
-	}
+	go func() {
+		// Errors like deadlines are passed on to the reader
+		var err error
+		if stderr {
+			_, err = stdcopy.StdCopy(io.Discard, pipeW, r)
+		} else {
+			_, err = stdcopy.StdCopy(pipeW, io.Discard, r)
+		}
+		pipeW.CloseWithError(err)
+	}()
 	return pipeR
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"log/slog"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -19,11 +20,13 @@ type Logger struct {
 	docker    *client.Client
 	container string
 	stderr    bool
+	log       *slog.Logger
 }
 
 type LogStream struct {
-	r    io.Reader
-	logs io.ReadCloser
+	r      io.Reader
+	logs   io.ReadCloser
+	cancel context.CancelFunc
 }
 
 func (s *LogStream) Read(p []byte) (n int, err error) {
@@ -35,24 +38,53 @@ func (s *LogStream) Write(p []byte) (n int, err error) {
 }
 
 func (s *LogStream) Close() error {
+	defer s.cancel()
 	return s.logs.Close()
 }
 
+// Connect implements the common.Streamer interface.
 func (l *Logger) Connect() (common.Stream, error) {
-	ctx := context.Background()
+	return l.ConnectContext(context.Background())
+}
+
+// ConnectContext implements the common.ContextStreamer interface.
+func (l *Logger) ConnectContext(ctx context.Context) (common.Stream, error) {
 	options := container.LogsOptions{
 		ShowStdout: !l.stderr,
 		ShowStderr: l.stderr,
 		Follow:     true,
 		Tail:       "1",
 	}
-	logs, err := l.docker.ContainerLogs(ctx, l.container, options)
+	// The logs are read until the stream is closed, so ctx may only cancel
+	// connecting
+	logsCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	logs, err := l.docker.ContainerLogs(logsCtx, l.container, options)
+	tty := err == nil && hasTty(l.docker, logsCtx, l.container)
+	if !stop() {
+		err = ctx.Err()
+	}
 	if err != nil {
+		if logs != nil {
+			logs.Close()
+		}
+		cancel()
+		l.log.DebugContext(ctx, "Failed to read container logs", "container", l.container, "err", err)
 		return nil, err
 	}
 
-	r := demuxStream(logs, hasTty(l.docker, ctx, l.container), l.stderr)
-	return &LogStream{r: r, logs: logs}, nil
+	r := demuxStream(logs, tty, l.stderr)
+	return &LogStream{r: r, logs: logs, cancel: cancel}, nil
+}
+
+// Start implements the common.Activator interface.
+func (l *Logger) Start() error {
+	return nil
+}
+
+// Stop implements the common.Activator interface.
+func (l *Logger) Stop() error {
+	return l.docker.Close()
 }
 
 func NewLogger(rawConfig json.RawMessage) (common.Streamer, error) {
@@ -69,9 +101,11 @@ func NewLogger(rawConfig json.RawMessage) (common.Streamer, error) {
 		docker:    docker,
 		container: config.Container,
 		stderr:    config.Stderr,
+		log:       common.Logger(),
 	}, nil
 }
 
 func init() {
 	common.Streamers.Register("docker.logs", NewLogger)
+	common.Streamers.RegisterConfig("docker.logs", LoggerConfig{})
 }
@@ -26,14 +26,20 @@ func (s Stream) Close() error {
 	return s.Conn.Close()
 }
 
+// Connect implements the common.Streamer interface.
 func (c *Attacher) Connect() (common.Stream, error) {
-	ctx := context.Background()
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext implements the common.ContextStreamer interface.
+func (c *Attacher) ConnectContext(ctx context.Context) (common.Stream, error) {
 	stream, err := c.docker.ContainerAttach(ctx, c.container, container.AttachOptions{
 		Stream: true,
 		Stdin:  true,
 		Stdout: true,
 	})
 	if err != nil {
+		attachFailures.Inc(c.container)
 		return Stream{}, err
 	}
 	r := demuxStream(stream.Reader, hasTty(c.docker, ctx, c.container), false)
@@ -8,7 +8,7 @@ import (
 	"errors"
 	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os/exec"
 	"strings"
@@ -24,6 +24,8 @@ type GitHubWebhook struct {
 	Path   string `json:"path"`
 	Branch string `json:"branch"`
 	Secret string `json:"secret"`
+
+	log *slog.Logger
 }
 
 var (
@@ -63,7 +65,7 @@ func (v *signatureValidator) Validate(sigHeader string) error {
 
 func (gh *GitHubWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
-		w.WriteHeader(http.StatusForbidden)
+		common.Error(w, req, http.StatusForbidden, "")
 		return
 	}
 
@@ -84,8 +86,8 @@ func (gh *GitHubWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var payload GitPullPayload
 	err := json.NewDecoder(jsonReader).Decode(&payload)
 	if err != nil {
-		log.Printf("json.Unmarshal failed: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+		gh.log.WarnContext(req.Context(), "Invalid payload", "err", err)
+		common.Error(w, req, http.StatusBadRequest, "invalid payload")
 		return
 	}
 	io.Copy(io.Discard, jsonReader) // so that HMAC receives all the body
@@ -94,25 +96,25 @@ func (gh *GitHubWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		sigStr := req.Header.Get("X-Hub-Signature")
 		err := validator.Validate(sigStr)
 		if err != nil {
-			log.Printf("Validate signature failed: %s\n", err)
-			http.Error(w, err.Error()+"\n", http.StatusForbidden)
+			gh.log.WarnContext(req.Context(), "Failed to validate signature", "err", err)
+			common.Error(w, req, http.StatusForbidden, err.Error())
 			return
 		}
 	}
 
 	if payload.Ref != "refs/heads/"+gh.Branch {
-		log.Printf("Ignoring ref %q\n", payload.Ref)
+		gh.log.InfoContext(req.Context(), "Ignoring push", "ref", payload.Ref)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	log.Printf("Received push to %q\n", payload.Ref)
+	gh.log.InfoContext(req.Context(), "Received push", "ref", payload.Ref)
 	cmd := exec.Command("/bin/sh", "-c", "git fetch origin "+gh.Branch+" && git reset --hard FETCH_HEAD")
 	cmd.Dir = gh.Path
 	err = cmd.Run()
 	if err != nil {
-		log.Printf("`git pull` failed: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		gh.log.ErrorContext(req.Context(), "`git pull` failed", "err", err)
+		common.Error(w, req, http.StatusInternalServerError, "git pull failed")
 		return
 	}
 
@@ -125,10 +127,13 @@ func NewGitHubWebhook(config json.RawMessage) (common.Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	gh.log = common.Logger()
 	return gh, nil
 }
 
 func init() {
 	common.Services.Register("github.webhook", NewGitHubWebhook)
 	common.Services.Register("github.webhook.pull", NewGitHubWebhook)
+	common.Services.RegisterConfig("github.webhook", GitHubWebhook{})
+	common.Services.RegisterConfig("github.webhook.pull", GitHubWebhook{})
 }
@@ -1,10 +1,11 @@
 package ibugauth
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -74,7 +75,7 @@ func ParseCasInfo(r io.Reader) (*CasInfo, error) {
 
 var stubCasInfo CasInfo
 
-func ValidateCasTicket(ticket string) (*CasInfo, error) {
+func ValidateCasTicket(ctx context.Context, ticket string) (*CasInfo, error) {
 	if ticket == "x" {
 		// debug ticket
 		return &stubCasInfo, nil
@@ -84,18 +85,23 @@ func ValidateCasTicket(ticket string) (*CasInfo, error) {
 	q.Add("service", CasService)
 	q.Add("ticket", ticket)
 	url.RawQuery = q.Encode()
-	resp, err := http.Get(url.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+	if err != nil {
+		return &stubCasInfo, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Println(err)
 		return &stubCasInfo, err
 	}
 	defer resp.Body.Close()
 	return ParseCasInfo(resp.Body)
 }
 
-type Service struct{}
+type Service struct {
+	log *slog.Logger
+}
 
-func (Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	ticket, ok := query["ticket"]
 	if !ok {
@@ -106,17 +112,17 @@ func (Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, url.String(), http.StatusFound)
 	}
 
-	info, err := ValidateCasTicket(ticket[0])
+	info, err := ValidateCasTicket(r.Context(), ticket[0])
 	if err != nil {
-		log.Printf("Validate CAS ticket error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.log.ErrorContext(r.Context(), "Failed to validate CAS ticket", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 	if info.AuthenticationSuccess != nil {
 		res := info.AuthenticationSuccess
-		log.Printf("CAS login by %s (%s) from %q\n", res.User, res.Attributes.Name, res.Attributes.LoginIP)
+		s.log.InfoContext(r.Context(), "CAS login", "user", res.User, "name", res.Attributes.Name, "login_ip", res.Attributes.LoginIP)
 	} else if info.AuthenticationFailure != nil {
-		w.WriteHeader(http.StatusForbidden)
+		common.Error(w, r, http.StatusForbidden, "")
 		return
 	}
 
@@ -130,9 +136,10 @@ func (Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func NewService(_ json.RawMessage) (common.Service, error) {
-	return Service{}, nil
+	return Service{log: common.Logger()}, nil
 }
 
 func init() {
 	common.Services.Register("ibug-auth", NewService)
+	common.Services.RegisterConfig("ibug-auth", struct{}{})
 }
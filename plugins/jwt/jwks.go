@@ -0,0 +1,254 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefetch limits how often an unknown key ID makes the key set be fetched
+// again before its refresh interval is up.
+const minRefetch = time.Minute
+
+// jwk is a JSON Web Key as in RFC 7517. Only public keys used for signatures
+// are of interest.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	alg string // restricts the key to one algorithm if set
+	key crypto.PublicKey
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeBase64(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("e: too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBase64(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeBase64(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBase64(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// parseJWKS parses a JWK Set, skipping keys that cannot be used to verify
+// signatures, and fails only if none are left.
+func parseJWKS(b []byte, log *slog.Logger) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	var keys []publicKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Debug("Skipping key", "index", i, "kid", k.Kid, "err", err)
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable keys")
+	}
+	return keys, nil
+}
+
+// keySet is a JWK Set read from a file or URL, and read again once refresh
+// has passed or when a token refers to an unknown key. Reading happens
+// without holding mu, so that other requests keep using the current keys.
+type keySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+	log     *slog.Logger
+
+	mu       sync.Mutex
+	keys     []publicKey
+	loadedAt time.Time     // of the last attempt, successful or not
+	loading  chan struct{} // closed when the running load is done, or nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+func (s *keySet) fetch() ([]byte, error) {
+	if !isURL(s.source) {
+		return os.ReadFile(s.source)
+	}
+	resp, err := s.client.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", s.source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// load reads the key set, keeping the previous keys on failure.
+func (s *keySet) load() error {
+	b, err := s.fetch()
+	if err == nil {
+		var keys []publicKey
+		keys, err = parseJWKS(b, s.log)
+		if err == nil {
+			s.mu.Lock()
+			s.keys = keys
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("load JWKS: %w", err)
+}
+
+// reload starts loading the key set in the background if the last attempt
+// was at least minAge ago, and returns a channel that is closed once it is
+// done. If a load is running already, its channel is returned instead, and
+// otherwise nil. Failures are logged with ctx.
+func (s *keySet) reload(ctx context.Context, minAge time.Duration) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loading != nil {
+		return s.loading
+	}
+	if time.Since(s.loadedAt) < minAge {
+		return nil
+	}
+	done := make(chan struct{})
+	s.loading = done
+	s.loadedAt = time.Now()
+	go func() {
+		if err := s.load(); err != nil {
+			s.log.ErrorContext(ctx, "Failed to refresh keys", "err", err)
+		}
+		s.mu.Lock()
+		s.loading = nil
+		s.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// wait waits for done, if not nil, or until ctx is done.
+func wait(ctx context.Context, done <-chan struct{}) {
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// lookup returns the keys that may have signed a token with kid and alg. A
+// due refresh only holds up requests if there are no keys yet, while an
+// unknown kid waits for the key set to be read again.
+func (s *keySet) lookup(ctx context.Context, kid, alg string) []publicKey {
+	s.mu.Lock()
+	loaded := s.keys != nil
+	s.mu.Unlock()
+	if done := s.reload(ctx, s.refresh); !loaded {
+		wait(ctx, done)
+	}
+	keys := s.match(kid, alg)
+	if len(keys) == 0 {
+		// The key may have been rotated in since
+		wait(ctx, s.reload(ctx, minRefetch))
+		keys = s.match(kid, alg)
+	}
+	return keys
+}
+
+func (s *keySet) match(kid, alg string) []publicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []publicKey
+	for _, k := range s.keys {
+		if (kid == "" || k.kid == kid) && (k.alg == "" || k.alg == alg) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,180 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+type Config struct {
+	// JWKS is the path or http(s) URL of the JWK Set to verify tokens with
+	JWKS    string `json:"jwks"`
+	Refresh string `json:"refresh"`
+	// Algorithms allowed, by default all supported ones
+	Algorithms []string `json:"algorithms"`
+
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	Leeway   string `json:"leeway"`
+	// Claims must have the given values, or be lists containing them
+	Claims map[string]any `json:"claims"`
+	// Groups lists groups of which the user must be in at least one,
+	// according to GroupsClaim ("groups" by default)
+	Groups      []string `json:"groups"`
+	GroupsClaim string   `json:"groups-claim"`
+
+	Service json.RawMessage `json:"service" registry:"service"`
+}
+
+// Validate implements the common.Validator interface.
+func (c Config) Validate() error {
+	var errs []error
+	if c.JWKS == "" {
+		errs = append(errs, &common.ConfigError{Path: "jwks", Err: common.ErrMissing})
+	}
+	for i, alg := range c.Algorithms {
+		if _, ok := algorithms[alg]; !ok {
+			errs = append(errs, &common.ConfigError{Path: fmt.Sprintf("algorithms.%d", i), Err: errors.New("unsupported algorithm")})
+		}
+	}
+	for _, key := range []struct{ key, value string }{{"refresh", c.Refresh}, {"leeway", c.Leeway}} {
+		if err := common.CheckDuration(key.key, key.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type verifier struct {
+	keys       *keySet
+	algorithms []string
+	issuer     string
+	audience   string
+	leeway     time.Duration
+}
+
+type Service struct {
+	next        common.Service
+	verifier    *verifier
+	claims      map[string]any
+	groups      []string
+	groupsClaim string
+	log         *slog.Logger
+}
+
+func NewService(rawConfig json.RawMessage) (common.Service, error) {
+	var config Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Service{
+		claims:      config.Claims,
+		groups:      config.Groups,
+		groupsClaim: config.GroupsClaim,
+		log:         common.Logger(),
+	}
+	if s.groupsClaim == "" {
+		s.groupsClaim = "groups"
+	}
+	s.verifier = &verifier{
+		keys: &keySet{
+			source:  config.JWKS,
+			refresh: common.ParseDurationDefault(config.Refresh, time.Hour),
+			client:  &http.Client{Timeout: 10 * time.Second},
+			log:     s.log,
+		},
+		algorithms: config.Algorithms,
+		issuer:     config.Issuer,
+		audience:   config.Audience,
+		leeway:     common.ParseDurationDefault(config.Leeway, time.Minute),
+	}
+	if s.verifier.algorithms == nil {
+		s.verifier.algorithms = slices.Sorted(maps.Keys(algorithms))
+	}
+	// A bad file is a config error, while a URL may be temporarily down
+	if !isURL(config.JWKS) {
+		if err := s.verifier.keys.load(); err != nil {
+			return nil, err
+		}
+		s.verifier.keys.loadedAt = time.Now()
+	}
+
+	next, err := common.Services.NewFromConfig(config.Service)
+	if err != nil {
+		return nil, err
+	}
+	s.next = next
+	return s, nil
+}
+
+// authorize checks the claims and groups required beyond a valid token.
+func (s *Service) authorize(claims Claims) error {
+	for _, name := range slices.Sorted(maps.Keys(s.claims)) {
+		if !claims.contains(name, s.claims[name]) {
+			return fmt.Errorf("claim %q does not match", name)
+		}
+	}
+	if len(s.groups) > 0 && !slices.ContainsFunc(s.groups, func(g string) bool {
+		return claims.contains(s.groupsClaim, g)
+	}) {
+		return errors.New("not in any allowed group")
+	}
+	return nil
+}
+
+// Unwrap implements the common.Wrapper interface.
+func (s *Service) Unwrap() common.Service {
+	return s.next
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		common.Error(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	claims, err := s.verifier.verify(r.Context(), strings.TrimSpace(token))
+	if err != nil {
+		s.log.DebugContext(r.Context(), "Rejected token", "err", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		common.Error(w, r, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	sub, _ := claims["sub"].(string)
+	if err := s.authorize(claims); err != nil {
+		s.log.DebugContext(r.Context(), "Denied token", "sub", sub, "err", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		common.Error(w, r, http.StatusForbidden, "insufficient scope")
+		return
+	}
+	common.GetRequestInfo(r.Context()).User = sub
+	s.next.ServeHTTP(w, r.WithContext(common.WithSubject(r.Context(), sub)))
+}
+
+// Start implements the common.Activator interface.
+func (s *Service) Start() error {
+	return common.Activate(s.next)
+}
+
+// Stop implements the common.Activator interface.
+func (s *Service) Stop() error {
+	return common.Deactivate(s.next)
+}
+
+func init() {
+	common.Services.Register("jwt", NewService)
+	common.Services.RegisterConfig("jwt", Config{})
+}
@@ -0,0 +1,202 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+func init() {
+	common.Services.Register("jwt-test", func(json.RawMessage) (common.Service, error) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(common.Subject(r.Context())))
+		}), nil
+	})
+}
+
+func encodeJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign returns a token with header and claims, signed with key if alg is
+// EdDSA, or with an empty signature otherwise.
+func sign(t *testing.T, key ed25519.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	signed := encodeJSON(t, header) + "." + encodeJSON(t, claims)
+	var sig []byte
+	if header["alg"] == "EdDSA" {
+		sig = ed25519.Sign(key, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// newTestService returns a jwt service verifying tokens against a JWKS file
+// with the public key of the returned private key, under kid "test".
+func newTestService(t *testing.T) (common.Service, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := map[string]any{"keys": []map[string]any{{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"kid": "test",
+		"x":   base64.RawURLEncoding.EncodeToString(pub),
+	}}}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	b, _ := json.Marshal(jwks)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := json.Marshal(map[string]any{
+		"jwks":       path,
+		"algorithms": []string{"EdDSA"},
+		"issuer":     "https://sso.example.com",
+		"audience":   "uniapi",
+		"leeway":     "1m",
+		"groups":     []string{"ops"},
+		"service":    map[string]any{"type": "jwt-test"},
+	})
+	s, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, priv
+}
+
+func TestServeHTTP(t *testing.T) {
+	s, key := newTestService(t)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	now := time.Now().Unix()
+	header := map[string]any{"alg": "EdDSA", "kid": "test"}
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":    "alice",
+			"iss":    "https://sso.example.com",
+			"aud":    "uniapi",
+			"exp":    now + 300,
+			"groups": []string{"ops"},
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		code      int
+		challenge string
+	}{
+		{"valid", sign(t, key, header, claims(nil)), http.StatusOK, ""},
+		{"alg none", sign(t, key, map[string]any{"alg": "none", "kid": "test"}, claims(nil)), http.StatusUnauthorized, "invalid_token"},
+		{"alg not allowed", sign(t, key, map[string]any{"alg": "RS256", "kid": "test"}, claims(nil)), http.StatusUnauthorized, "invalid_token"},
+		{"alg HS256", sign(t, key, map[string]any{"alg": "HS256", "kid": "test"}, claims(nil)), http.StatusUnauthorized, "invalid_token"},
+		{"unknown kid", sign(t, key, map[string]any{"alg": "EdDSA", "kid": "other"}, claims(nil)), http.StatusUnauthorized, "invalid_token"},
+		{"wrong key", sign(t, otherKey, header, claims(nil)), http.StatusUnauthorized, "invalid_token"},
+		{"missing exp", sign(t, key, header, claims(map[string]any{"exp": nil})), http.StatusUnauthorized, "invalid_token"},
+		{"expired within leeway", sign(t, key, header, claims(map[string]any{"exp": now - 30})), http.StatusOK, ""},
+		{"expired", sign(t, key, header, claims(map[string]any{"exp": now - 120})), http.StatusUnauthorized, "invalid_token"},
+		{"nbf within leeway", sign(t, key, header, claims(map[string]any{"nbf": now + 30})), http.StatusOK, ""},
+		{"nbf", sign(t, key, header, claims(map[string]any{"nbf": now + 120})), http.StatusUnauthorized, "invalid_token"},
+		{"wrong issuer", sign(t, key, header, claims(map[string]any{"iss": "https://evil.example.com"})), http.StatusUnauthorized, "invalid_token"},
+		{"aud list", sign(t, key, header, claims(map[string]any{"aud": []string{"other", "uniapi"}})), http.StatusOK, ""},
+		{"aud list without audience", sign(t, key, header, claims(map[string]any{"aud": []string{"other"}})), http.StatusUnauthorized, "invalid_token"},
+		{"wrong group", sign(t, key, header, claims(map[string]any{"groups": []string{"dev"}})), http.StatusForbidden, "insufficient_scope"},
+		{"no groups", sign(t, key, header, claims(map[string]any{"groups": nil})), http.StatusForbidden, "insufficient_scope"},
+		{"malformed", "not.a.token", http.StatusUnauthorized, "invalid_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, tt.challenge) {
+				t.Errorf("WWW-Authenticate is %q, want %q", got, tt.challenge)
+			}
+			if tt.code == http.StatusOK && w.Body.String() != "alice" {
+				t.Errorf("subject is %q, want %q", w.Body, "alice")
+			}
+		})
+	}
+}
+
+func TestServeHTTPWithoutToken(t *testing.T) {
+	s, _ := newTestService(t)
+	for _, auth := range []string{"", "Bearer", "Basic YWxpY2U6cGFzc3dvcmQ="} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q: got %d with WWW-Authenticate %q", auth, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	s, _ := newTestService(t)
+	keys := s.(*Service).verifier.keys
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]any{{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"kid": "new",
+		"x":   base64.RawURLEncoding.EncodeToString(pub),
+	}}})
+	if err := os.WriteFile(keys.source, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, priv, map[string]any{"alg": "EdDSA", "kid": "new"}, map[string]any{
+		"sub":    "bob",
+		"iss":    "https://sso.example.com",
+		"aud":    "uniapi",
+		"exp":    time.Now().Unix() + 300,
+		"groups": []string{"ops"},
+	})
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Read just now, so the unknown kid must not cause another read
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Fatalf("got %d before the key set may be read again, want %d", code, http.StatusUnauthorized)
+	}
+	keys.mu.Lock()
+	keys.loadedAt = time.Now().Add(-minRefetch)
+	keys.mu.Unlock()
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("got %d after the key set may be read again, want %d", code, http.StatusOK)
+	}
+}
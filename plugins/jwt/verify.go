@@ -0,0 +1,192 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// algorithms are the supported signature algorithms. Symmetric ones (HS256
+// etc.) are deliberately left out, as are unsigned tokens.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// esCurves are the curves that the ES algorithms are defined for.
+var esCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+var (
+	errInvalid   = errors.New("invalid token")
+	errSignature = errors.New("invalid signature")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+// verifySignature checks sig over signed with key, as specified for alg by
+// RFC 7518 and RFC 8037.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	hash := algorithms[alg]
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(key, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if key.Curve.Params().Name != esCurves[alg] || len(sig) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if ecdsa.Verify(key, digest, r, s) {
+			return nil
+		}
+		return errSignature
+	case ed25519.PublicKey:
+		if alg == "EdDSA" && ed25519.Verify(key, signed, sig) {
+			return nil
+		}
+	}
+	return errSignature
+}
+
+// verify checks the signature and the time-based claims of token, and
+// returns its claims.
+func (v *verifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalid
+	}
+	b, err := decodeBase64(parts[0])
+	if err != nil {
+		return nil, errInvalid
+	}
+	var h header
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, errInvalid
+	}
+	if !slices.Contains(v.algorithms, h.Alg) {
+		return nil, fmt.Errorf("algorithm %q not allowed", h.Alg)
+	}
+	sig, err := decodeBase64(parts[2])
+	if err != nil {
+		return nil, errInvalid
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	keys := v.keys.lookup(ctx, h.Kid, h.Alg)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown key %q", h.Kid)
+	}
+	err = errSignature
+	for _, k := range keys {
+		if err = verifySignature(h.Alg, k.key, signed, sig); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errSignature
+	}
+
+	if b, err = decodeBase64(parts[1]); err != nil {
+		return nil, errInvalid
+	}
+	var claims Claims
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&claims); err != nil {
+		return nil, errInvalid
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), true
+}
+
+// contains reports whether claim name is value, or is a list containing it.
+func (c Claims) contains(name string, value any) bool {
+	switch claim := c[name].(type) {
+	case []any:
+		return slices.ContainsFunc(claim, func(v any) bool { return claimEqual(v, value) })
+	case nil:
+		return false
+	default:
+		return claimEqual(claim, value)
+	}
+}
+
+// claimEqual compares a claim decoded with UseNumber to a value from the
+// config.
+func claimEqual(claim, value any) bool {
+	if n, ok := claim.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return false
+		}
+		claim = f
+	}
+	return reflect.DeepEqual(claim, value)
+}
+
+func (v *verifier) checkClaims(c Claims) error {
+	now := time.Now()
+	exp, ok := c.time("exp")
+	if !ok {
+		return errors.New("missing exp")
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return errors.New("expired")
+	}
+	if nbf, ok := c.time("nbf"); ok && now.Before(nbf.Add(-v.leeway)) {
+		return errors.New("not valid yet")
+	}
+	if v.issuer != "" && c["iss"] != v.issuer {
+		return fmt.Errorf("wrong issuer %v", c["iss"])
+	}
+	if v.audience != "" && !c.contains("aud", v.audience) {
+		return errors.New("wrong audience")
+	}
+	return nil
+}
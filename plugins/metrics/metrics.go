@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+type Service struct {
+	log *slog.Logger
+}
+
+// Describe implements the common.Describer interface.
+func (Service) Describe() string {
+	return "Prometheus metrics"
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := common.WriteMetrics(w); err != nil {
+		s.log.WarnContext(r.Context(), "Failed to write metrics", "err", err)
+	}
+}
+
+func NewService(_ json.RawMessage) (common.Service, error) {
+	return Service{log: common.Logger()}, nil
+}
+
+func init() {
+	common.Services.Register("metrics", NewService)
+	common.Services.RegisterConfig("metrics", struct{}{})
+}
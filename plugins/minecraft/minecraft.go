@@ -1,8 +1,9 @@
 package minecraft
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -25,6 +26,7 @@ type Config struct {
 
 type Client struct {
 	commander common.Commander
+	log       *slog.Logger
 }
 
 func NewClient(rawConfig json.RawMessage) (common.Service, error) {
@@ -37,14 +39,14 @@ func NewClient(rawConfig json.RawMessage) (common.Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{commander}, nil
+	return &Client{commander, common.Logger()}, nil
 }
 
 var RePlayerList = *regexp.MustCompile(`^There are (\d+) of a max of (\d+) players online: `)
 
-func (c *Client) GetStatus() (Status, error) {
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
 	status := Status{}
-	msg, err := c.commander.Execute("list")
+	msg, err := common.ExecuteContext(ctx, c.commander, "list")
 	if err != nil {
 		return status, err
 	}
@@ -59,17 +61,37 @@ func (c *Client) GetStatus() (Status, error) {
 	return status, nil
 }
 
+// Start implements the common.Activator interface.
+func (c *Client) Start() error {
+	return common.Activate(c.commander)
+}
+
+// Stop implements the common.Activator interface.
+func (c *Client) Stop() error {
+	return common.Deactivate(c.commander)
+}
+
+// Describe implements the common.Describer interface.
+func (c *Client) Describe() string {
+	return "Minecraft server status"
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	status, err := c.GetStatus()
+	status, err := c.GetStatus(r.Context())
+	service := common.MountPath(r.Context())
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"status": "internal server error"}`))
+		common.GameUp.Set(0, service)
+		c.log.ErrorContext(r.Context(), "Failed to get status", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 
+	common.GameUp.Set(1, service)
+	common.GamePlayers.Set(float64(status.Count), service)
+	common.GameMaxPlayers.Set(float64(status.MaxCount), service)
+
 	w.Header().Set("Cache-Control", "public, max-age=5")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(status)
@@ -77,4 +99,5 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func init() {
 	common.Services.Register("minecraft", NewClient)
+	common.Services.RegisterConfig("minecraft", Config{})
 }
@@ -2,8 +2,9 @@ package palworld
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -22,6 +23,7 @@ type Config struct {
 
 type Client struct {
 	commander common.Commander
+	log       *slog.Logger
 }
 
 func NewClient(rawConfig json.RawMessage) (common.Service, error) {
@@ -34,12 +36,12 @@ func NewClient(rawConfig json.RawMessage) (common.Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{commander}, nil
+	return &Client{commander, common.Logger()}, nil
 }
 
-func (c *Client) GetStatus() (Status, error) {
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
 	status := Status{}
-	msg, err := c.commander.Execute("ShowPlayers")
+	msg, err := common.ExecuteContext(ctx, c.commander, "ShowPlayers")
 	if err != nil {
 		return status, err
 	}
@@ -54,17 +56,36 @@ func (c *Client) GetStatus() (Status, error) {
 	return status, nil
 }
 
+// Start implements the common.Activator interface.
+func (c *Client) Start() error {
+	return common.Activate(c.commander)
+}
+
+// Stop implements the common.Activator interface.
+func (c *Client) Stop() error {
+	return common.Deactivate(c.commander)
+}
+
+// Describe implements the common.Describer interface.
+func (c *Client) Describe() string {
+	return "Palworld server status"
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	status, err := c.GetStatus()
+	status, err := c.GetStatus(r.Context())
+	service := common.MountPath(r.Context())
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"status": "internal server error"}`))
+		common.GameUp.Set(0, service)
+		c.log.ErrorContext(r.Context(), "Failed to get status", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 
+	common.GameUp.Set(1, service)
+	common.GamePlayers.Set(float64(len(status.Players)), service)
+
 	w.Header().Set("Cache-Control", "public, max-age=5")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(status)
@@ -72,4 +93,5 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func init() {
 	common.Services.Register("palworld", NewClient)
+	common.Services.RegisterConfig("palworld", Config{})
 }
@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iBug/uniAPI/common"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// sweepInterval is how often limiters of clients that have gone quiet
+	// are dropped.
+	sweepInterval = time.Minute
+	// maxClients is how many clients are kept track of at most.
+	maxClients = 100000
+)
+
+var rejected = common.NewCounterVec("uniapi_ratelimit_rejected_total",
+	"Requests rejected by rate limits by service and reason.", "service", "reason")
+
+type Config struct {
+	// Requests are allowed per Per ("1s" by default) for each client, with
+	// bursts of up to Burst (Requests by default)
+	Requests float64 `json:"requests"`
+	Per      string  `json:"per"`
+	Burst    int     `json:"burst"`
+	// Key is what tells clients apart, "ip" (the default) or "token" for
+	// the user or token name that requests authenticated as
+	Key string `json:"key"`
+	// MaxConcurrent limits requests in flight across all clients
+	MaxConcurrent int `json:"max-concurrent"`
+
+	Service json.RawMessage `json:"service" registry:"service"`
+}
+
+// Validate implements the common.Validator interface.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Requests < 0 {
+		errs = append(errs, &common.ConfigError{Path: "requests", Err: errors.New("must not be negative")})
+	}
+	if c.Burst < 0 {
+		errs = append(errs, &common.ConfigError{Path: "burst", Err: errors.New("must not be negative")})
+	}
+	if c.MaxConcurrent < 0 {
+		errs = append(errs, &common.ConfigError{Path: "max-concurrent", Err: errors.New("must not be negative")})
+	}
+	if c.Requests == 0 && c.MaxConcurrent == 0 {
+		errs = append(errs, errors.New("at least one of requests and max-concurrent is required"))
+	}
+	if err := common.CheckDuration("per", c.Per); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.Key {
+	case "", "ip", "token":
+	default:
+		errs = append(errs, &common.ConfigError{Path: "key", Err: errors.New(`must be "ip" or "token"`)})
+	}
+	return errors.Join(errs...)
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type Service struct {
+	next     common.Service
+	limit    rate.Limit // 0 if only concurrency is limited
+	burst    int
+	keyToken bool
+	sem      chan struct{} // nil if concurrency is not limited
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+func NewService(rawConfig json.RawMessage) (common.Service, error) {
+	var config Config
+	if err := json.Unmarshal(rawConfig, &config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	per := common.ParseDurationDefault(config.Per, time.Second)
+	s := &Service{
+		limit:     rate.Limit(config.Requests / per.Seconds()),
+		burst:     config.Burst,
+		keyToken:  config.Key == "token",
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+	if s.burst == 0 {
+		s.burst = max(1, int(math.Ceil(config.Requests)))
+	}
+	if config.MaxConcurrent > 0 {
+		s.sem = make(chan struct{}, config.MaxConcurrent)
+	}
+	next, err := common.Services.NewFromConfig(config.Service)
+	if err != nil {
+		return nil, err
+	}
+	s.next = next
+	return s, nil
+}
+
+// key returns what the client of r is told apart by. With keyToken, that is
+// the user or token name that an enclosing auth or jwt service
+// authenticated r as, if any, and otherwise the client address, so that
+// made-up tokens do not get a bucket of their own. The prefixes keep the
+// kinds of keys apart.
+func (s *Service) key(r *http.Request) string {
+	if s.keyToken {
+		if user := common.GetRequestInfo(r.Context()).User; user != "" {
+			return "user:" + user
+		}
+	}
+	return "ip:" + common.ClientAddr(r).String()
+}
+
+// sweep drops the limiters whose buckets have been refilled, and if there
+// are still too many, any others until there is room for a tenth more. It
+// must be called with mu held.
+func (s *Service) sweep(now time.Time) {
+	// Buckets that have been refilled are the same as new ones
+	full := time.Duration(float64(s.burst) / float64(s.limit) * float64(time.Second))
+	for k, c := range s.clients {
+		if now.Sub(c.lastSeen) > full {
+			delete(s.clients, k)
+		}
+	}
+	for k := range s.clients {
+		if len(s.clients) < maxClients*9/10 {
+			break
+		}
+		delete(s.clients, k)
+	}
+	s.lastSweep = now
+}
+
+// reserve takes a token from the bucket of the client of r, and returns how
+// long the client should wait instead if there is none.
+func (s *Service) reserve(r *http.Request) time.Duration {
+	now := time.Now()
+	key := s.key(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[key]
+	if !ok {
+		if len(s.clients) >= maxClients || now.Sub(s.lastSweep) >= sweepInterval {
+			s.sweep(now)
+		}
+		c = &client{limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.clients[key] = c
+	}
+	c.lastSeen = now
+	res := c.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		res.CancelAt(now)
+	}
+	return delay
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, reason string) {
+	rejected.Inc(common.MountPath(r.Context()), reason)
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, secs)))
+	common.Error(w, r, http.StatusTooManyRequests, "")
+}
+
+// Unwrap implements the common.Wrapper interface.
+func (s *Service) Unwrap() common.Service {
+	return s.next
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.limit > 0 {
+		if delay := s.reserve(r); delay > 0 {
+			tooManyRequests(w, r, delay, "rate")
+			return
+		}
+	}
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+			defer func() { <-s.sem }()
+		default:
+			tooManyRequests(w, r, time.Second, "concurrency")
+			return
+		}
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+// Start implements the common.Activator interface.
+func (s *Service) Start() error {
+	return common.Activate(s.next)
+}
+
+// Stop implements the common.Activator interface.
+func (s *Service) Stop() error {
+	return common.Deactivate(s.next)
+}
+
+func init() {
+	common.Services.Register("ratelimit", NewService)
+	common.Services.RegisterConfig("ratelimit", Config{})
+}
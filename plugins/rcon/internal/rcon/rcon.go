@@ -6,6 +6,7 @@ package rcon
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -68,11 +69,14 @@ type Client struct {
 	timeout  time.Duration
 
 	reqID   int32
-	tcpConn *net.TCPConn
+	tcpConn *net.TCPConn // replaced with connMu held too
+	ctx     context.Context
 
-	checkReqID bool
+	checkReqID  bool
+	onReconnect func(ctx context.Context, err error)
 
-	lock sync.Mutex
+	lock   sync.Mutex
+	connMu sync.Mutex
 }
 
 // New return pointer to a new client, it's safe for concurrency use
@@ -96,15 +100,72 @@ func (c *Client) SetCheckRequestID(b bool) {
 	c.checkReqID = b
 }
 
+// SetReconnectHook sets a function to be called whenever the client replaces
+// a broken connection with a new one, with the context of the command and
+// the error that the old connection failed with.
+func (c *Client) SetReconnectHook(f func(ctx context.Context, err error)) {
+	c.onReconnect = f
+}
+
 // Execute the command.
 // Execute once if no "\n" provided. Return result message and nil on success, empty string and an error on failure.
 // If cmd includes "\n", it is treated as a script file. Splitted and trimmed into lines. Line starts with "//" will
 // be treated as comment and ignored. When all commands seccess, concatted messages and nil will be returned.
 // Once failed, concatted previous succeeded messages and an error will be returned.
 func (c *Client) Execute(cmd string) (string, error) {
+	return c.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext is like Execute, but gives up once ctx is done, dropping
+// the connection if a command was in progress.
+func (c *Client) ExecuteContext(ctx context.Context, cmd string) (string, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	c.ctx = ctx
+	stop := context.AfterFunc(ctx, c.interrupt)
+	defer func() {
+		if !stop() {
+			// The connection may be left with a partial response
+			c.disconnect()
+			c.setConn(nil)
+		}
+		c.ctx = nil
+	}()
+	out, err := c.execute(cmd)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		err = fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return out, err
+}
 
+// interrupt makes the command in progress fail.
+func (c *Client) interrupt() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.tcpConn != nil {
+		c.tcpConn.SetDeadline(time.Now())
+	}
+}
+
+func (c *Client) setConn(conn *net.TCPConn) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.tcpConn = conn
+}
+
+// deadline returns the deadline for the command in progress.
+func (c *Client) deadline() time.Time {
+	t := time.Now().Add(c.timeout)
+	if d, ok := c.ctx.Deadline(); ok && d.Before(t) {
+		return d
+	}
+	return t
+}
+
+func (c *Client) execute(cmd string) (string, error) {
 	cmds := strings.Split(cmd, "\n")
 	if len(cmds) == 1 {
 		return c.executeWorker(cmd)
@@ -127,23 +188,43 @@ func (c *Client) Execute(cmd string) (string, error) {
 	return builder.String(), nil
 }
 
+// Close the connection to the server, if any. The client remains usable and
+// will reconnect on the next Execute.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.disconnect()
+	c.setConn(nil)
+	return err
+}
+
 func (c *Client) executeWorker(cmd string) (string, error) {
+	if c.tcpConn != nil {
+		c.tcpConn.SetDeadline(c.deadline())
+	}
 	err := c.send(serverdataExecCommand, cmd)
 	if err != nil {
-		return c.executeRetry(cmd)
+		return c.executeRetry(cmd, err)
 	}
 	str1, err := c.receive()
 	if err != nil {
-		return c.executeRetry(cmd)
+		return c.executeRetry(cmd, err)
 	}
 	return str1, nil
 }
 
-func (c *Client) executeRetry(cmd string) (string, error) {
+func (c *Client) executeRetry(cmd string, err error) (string, error) {
+	if c.ctx.Err() != nil {
+		return "", err
+	}
+	reconnect := c.tcpConn != nil
 	c.disconnect()
 	if err := c.connect(); err != nil {
 		return "", err
 	}
+	if reconnect && c.onReconnect != nil {
+		c.onReconnect(c.ctx, err)
+	}
 	c.send(serverdataAuth, c.password)
 
 	auth, err := c.receive()
@@ -176,7 +257,8 @@ func (c *Client) disconnect() error {
 }
 
 func (c *Client) connect() error {
-	conn, err := net.DialTimeout(tcpNetworkName, c.address, c.timeout)
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(c.ctx, tcpNetworkName, c.address)
 	if err != nil {
 		return err
 	}
@@ -186,8 +268,12 @@ func (c *Client) connect() error {
 		return ErrDialTCPFail
 	}
 
-	c.tcpConn = tcpConn
-	c.tcpConn.SetDeadline(time.Now().Add(c.timeout))
+	tcpConn.SetDeadline(c.deadline())
+	c.setConn(tcpConn)
+	if c.ctx.Err() != nil {
+		// ctx was done before interrupt could see the connection
+		tcpConn.SetDeadline(time.Now())
+	}
 	return nil
 }
 
@@ -239,7 +325,7 @@ func (c *Client) receive() (string, error) {
 		// read & parse packet length
 		packetSizeBuffer := make([]byte, 4)
 		if _, err := io.ReadFull(reader, packetSizeBuffer); err != nil {
-			return "", ErrConnectionClosed
+			return "", fmt.Errorf("%w: %w", ErrConnectionClosed, err)
 		}
 		packetSize := int32(binary.LittleEndian.Uint32(packetSizeBuffer))
 		if packetSize < minMessageLength || packetSize > maxMessageLength {
@@ -249,7 +335,7 @@ func (c *Client) receive() (string, error) {
 		// read packet data
 		packetBuffer := make([]byte, packetSize)
 		if _, err := io.ReadFull(reader, packetBuffer); err != nil {
-			return "", ErrConnectionClosed
+			return "", fmt.Errorf("%w: %w", ErrConnectionClosed, err)
 		}
 
 		// parse the packet
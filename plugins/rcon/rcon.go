@@ -1,8 +1,11 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/iBug/uniAPI/common"
@@ -16,12 +19,76 @@ type Config struct {
 	Timeout    string `json:"timeout"`
 }
 
-func NewClient(config Config) *rcon.Client {
-	return rcon.New(
-		fmt.Sprintf("%s:%d", config.ServerAddr, config.ServerPort),
+var (
+	rconCommands = common.NewCounterVec("uniapi_rcon_commands_total",
+		"RCON commands executed by server address.", "server")
+	rconErrors = common.NewCounterVec("uniapi_rcon_errors_total",
+		"RCON commands that failed by server address.", "server")
+	rconReconnects = common.NewCounterVec("uniapi_rcon_reconnects_total",
+		"RCON connections replaced after an error by server address.", "server")
+)
+
+// Commander wraps an RCON client so that its connection is closed when the
+// owning service is stopped.
+type Commander struct {
+	*rcon.Client
+	address string
+	log     *slog.Logger
+}
+
+// Execute implements the common.Commander interface.
+func (c Commander) Execute(cmd string) (string, error) {
+	return c.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext implements the common.ContextCommander interface.
+func (c Commander) ExecuteContext(ctx context.Context, cmd string) (string, error) {
+	out, err := c.Client.ExecuteContext(ctx, cmd)
+	rconCommands.Inc(c.address)
+	if err != nil {
+		rconErrors.Inc(c.address)
+		c.log.DebugContext(ctx, "RCON command failed", "server", c.address, "err", err)
+	}
+	return out, err
+}
+
+// Start implements the common.Activator interface.
+func (c Commander) Start() error {
+	return nil
+}
+
+// Stop implements the common.Activator interface.
+func (c Commander) Stop() error {
+	return c.Close()
+}
+
+// Validate implements the common.Validator interface.
+func (c Config) Validate() error {
+	if c.ServerAddr == "" {
+		return &common.ConfigError{Path: "server", Err: common.ErrMissing}
+	}
+	if c.ServerPort <= 0 || c.ServerPort > 65535 {
+		return &common.ConfigError{Path: "port", Err: errors.New("must be between 1 and 65535")}
+	}
+	return common.CheckDuration("timeout", c.Timeout)
+}
+
+func address(config Config) string {
+	return fmt.Sprintf("%s:%d", config.ServerAddr, config.ServerPort)
+}
+
+// NewClient returns an RCON client for config, which logs reconnects to log.
+func NewClient(config Config, log *slog.Logger) *rcon.Client {
+	client := rcon.New(
+		address(config),
 		config.Password,
 		common.ParseDurationDefault(config.Timeout, 1*time.Second),
 	)
+	client.SetReconnectHook(func(ctx context.Context, err error) {
+		rconReconnects.Inc(address(config))
+		log.InfoContext(ctx, "Reconnected to RCON server", "server", address(config), "err", err)
+	})
+	return client
 }
 
 func NewCommander(rawConfig json.RawMessage) (common.Commander, error) {
@@ -30,9 +97,11 @@ func NewCommander(rawConfig json.RawMessage) (common.Commander, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewClient(config), nil
+	log := common.Logger()
+	return Commander{NewClient(config, log), address(config), log}, nil
 }
 
 func init() {
 	common.Commanders.Register("rcon", NewCommander)
+	common.Commanders.RegisterConfig("rcon", Config{})
 }
@@ -9,6 +9,11 @@ import (
 
 type Service struct{}
 
+// Describe implements the common.Describer interface.
+func (Service) Describe() string {
+	return "robots.txt disallowing all crawlers"
+}
+
 func (Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -21,4 +26,5 @@ func NewService(_ json.RawMessage) (common.Service, error) {
 
 func init() {
 	common.Services.Register("robotstxt", NewService)
+	common.Services.RegisterConfig("robotstxt", struct{}{})
 }
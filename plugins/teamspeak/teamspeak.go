@@ -1,9 +1,10 @@
 package teamspeak
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strconv"
@@ -19,6 +20,14 @@ type Config struct {
 	Timeout  string `json:"timeout"`
 }
 
+// Validate implements the common.Validator interface.
+func (c Config) Validate() error {
+	if c.Endpoint == "" {
+		return &common.ConfigError{Path: "endpoint", Err: common.ErrMissing}
+	}
+	return common.CheckDuration("timeout", c.Timeout)
+}
+
 type TSQueryResponse struct {
 	Status struct {
 		Code         int    `json:"code"`
@@ -99,6 +108,7 @@ type Client struct {
 	instance   string
 	key        string
 	httpClient *http.Client
+	log        *slog.Logger
 }
 
 func NewClient(config Config) *Client {
@@ -109,12 +119,13 @@ func NewClient(config Config) *Client {
 		httpClient: &http.Client{
 			Timeout: common.ParseDurationDefault(config.Timeout, 1*time.Second),
 		},
+		log: common.Logger(),
 	}
 }
 
-func (c *Client) QueryHTTP(method string) (*http.Response, error) {
+func (c *Client) QueryHTTP(ctx context.Context, method string) (*http.Response, error) {
 	url := fmt.Sprintf("%s/%s/%s", c.endpoint, c.instance, method)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -123,16 +134,16 @@ func (c *Client) QueryHTTP(method string) (*http.Response, error) {
 	return c.httpClient.Do(req)
 }
 
-func (c *Client) Query(method string, body any) error {
+func (c *Client) Query(ctx context.Context, method string, body any) error {
 	retries := 0
-	resp, err := c.QueryHTTP(method)
+	resp, err := c.QueryHTTP(ctx, method)
 	for err != nil {
 		retries++
-		log.Printf("Teamspeak query error %d: %v", retries, err)
+		c.log.WarnContext(ctx, "Query failed", "method", method, "retries", retries, "err", err)
 		if retries >= 3 {
 			return err
 		}
-		resp, err = c.QueryHTTP(method)
+		resp, err = c.QueryHTTP(ctx, method)
 	}
 	defer resp.Body.Close()
 
@@ -144,18 +155,18 @@ func (c *Client) Query(method string, body any) error {
 	return nil
 }
 
-func (c *Client) GetClients() ([]TSClient, error) {
+func (c *Client) GetClients(ctx context.Context) ([]TSClient, error) {
 	clients := make([]TSClient, 0)
-	err := c.Query("clientlist", &clients)
+	err := c.Query(ctx, "clientlist", &clients)
 	if err != nil {
 		return nil, err
 	}
 	return clients, nil
 }
 
-func (c *Client) GetChannels() ([]TSChannel, error) {
+func (c *Client) GetChannels(ctx context.Context) ([]TSChannel, error) {
 	channels := make([]TSChannel, 0)
-	err := c.Query("channellist", &channels)
+	err := c.Query(ctx, "channellist", &channels)
 	if err != nil {
 		return nil, err
 	}
@@ -170,14 +181,14 @@ type Status struct {
 	Clients  []TSClient  `json:"clients"`
 }
 
-func (c *Client) GetOnline() (result Status, err error) {
+func (c *Client) GetOnline(ctx context.Context) (result Status, err error) {
 	result.Time = time.Now().Truncate(time.Second)
 
-	clients, err := c.GetClients()
+	clients, err := c.GetClients(ctx)
 	if err != nil {
 		return
 	}
-	channels, err := c.GetChannels()
+	channels, err := c.GetChannels(ctx)
 	if err != nil {
 		return
 	}
@@ -208,12 +219,17 @@ func (c *Client) GetOnline() (result Status, err error) {
 	return
 }
 
+// Describe implements the common.Describer interface.
+func (c *Client) Describe() string {
+	return "TeamSpeak channels and clients"
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	result, err := c.GetOnline()
+	result, err := c.GetOnline(r.Context())
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.log.ErrorContext(r.Context(), "Failed to get status", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -232,4 +248,5 @@ func NewService(config json.RawMessage) (common.Service, error) {
 
 func init() {
 	common.Services.Register("teamspeak", NewService)
+	common.Services.RegisterConfig("teamspeak", Config{})
 }
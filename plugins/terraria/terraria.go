@@ -2,14 +2,16 @@ package terraria
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/iBug/uniAPI/common"
@@ -32,6 +34,7 @@ type Config struct {
 
 type Client struct {
 	streamer common.Streamer
+	log      *slog.Logger
 }
 
 func NewClient(b json.RawMessage) (common.Service, error) {
@@ -45,16 +48,21 @@ func NewClient(b json.RawMessage) (common.Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{streamer: streamer}, nil
+	return &Client{streamer: streamer, log: common.Logger()}, nil
 }
 
-func (c *Client) GetStatus() (Status, error) {
+func (c *Client) GetStatus(ctx context.Context) (Status, error) {
 	status := Status{Time: time.Now().Truncate(time.Second)}
-	stream, err := c.streamer.Connect()
+	stream, err := common.ConnectContext(ctx, c.streamer)
 	if err != nil {
 		return status, fmt.Errorf("connect: %w", err)
 	}
-	defer stream.Close()
+	// Closing the stream interrupts reading from it, and it must only be
+	// closed once
+	closeStream := sync.OnceValue(stream.Close)
+	defer closeStream()
+	stop := context.AfterFunc(ctx, func() { closeStream() })
+	defer stop()
 	r := bufio.NewReader(stream)
 
 	_, err = stream.Write([]byte("playing\n"))
@@ -109,16 +117,31 @@ func (c *Client) GetStatus() (Status, error) {
 	return status, nil
 }
 
+// Start implements the common.Activator interface.
+func (c *Client) Start() error {
+	return common.Activate(c.streamer)
+}
+
+// Stop implements the common.Activator interface.
+func (c *Client) Stop() error {
+	return common.Deactivate(c.streamer)
+}
+
+// Describe implements the common.Describer interface.
+func (c *Client) Describe() string {
+	return "Terraria server status"
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if true {
-		w.WriteHeader(http.StatusInternalServerError)
+		common.Error(w, r, http.StatusInternalServerError, "")
 		return
 	}
-	status, err := c.GetStatus()
+	status, err := c.GetStatus(r.Context())
 	if err != nil {
-		log.Printf("Terraria error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.log.ErrorContext(r.Context(), "Failed to get status", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -127,4 +150,5 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func init() {
 	common.Services.Register("terraria", NewClient)
+	common.Services.RegisterConfig("terraria", Config{})
 }
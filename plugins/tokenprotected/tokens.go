@@ -2,48 +2,53 @@ package tokenprotected
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/iBug/uniAPI/common"
 )
 
-func validateToken(header string, tokens []string) bool {
-	parts := strings.Fields(header)
-	if len(parts) == 0 || len(parts) > 2 {
-		return false
-	}
-	token := parts[0]
-	switch strings.ToLower(parts[0]) {
-	case "bearer", "token":
-		token = parts[1]
-	}
-	for _, t := range tokens {
-		if token == t {
-			return true
-		}
+type TokenProtectedConfig struct {
+	Tokens []common.Token `json:"tokens"`
+	// Local is the policy for local requests as in the auth service
+	Local   string          `json:"local"`
+	Service json.RawMessage `json:"service" registry:"service"`
+}
+
+func (c TokenProtectedConfig) authConfig() common.AuthConfig {
+	return common.AuthConfig{
+		Local:  c.Local,
+		Bearer: &common.BearerConfig{Tokens: c.Tokens},
 	}
-	return false
 }
 
-type TokenProtectedConfig struct {
-	Tokens  []string        `json:"tokens"`
-	Service json.RawMessage `json:"service"`
+// Validate implements the common.Validator interface.
+func (c TokenProtectedConfig) Validate() error {
+	config := c.authConfig()
+	return errors.Join(config.Validate(), config.Bearer.Validate())
 }
 
 type TokenProtectedService struct {
-	next   common.Service
-	tokens []string
+	next    common.Service
+	handler http.Handler
+}
+
+// Unwrap implements the common.Wrapper interface.
+func (s *TokenProtectedService) Unwrap() common.Service {
+	return s.next
 }
 
 // ServeHTTP implements the http.Handler interface.
 func (s *TokenProtectedService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("CF-Connecting-IP") != "" &&
-		!validateToken(r.Header.Get("Authorization"), s.tokens) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-	s.next.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *TokenProtectedService) Start() error {
+	return common.Activate(s.next)
+}
+
+func (s *TokenProtectedService) Stop() error {
+	return common.Deactivate(s.next)
 }
 
 func NewTokenProtectedService(rawConfig json.RawMessage) (common.Service, error) {
@@ -51,16 +56,24 @@ func NewTokenProtectedService(rawConfig json.RawMessage) (common.Service, error)
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	auth, err := common.NewAuthenticator(config.authConfig())
+	if err != nil {
+		return nil, err
+	}
 	next, err := common.Services.NewFromConfig(config.Service)
 	if err != nil {
 		return nil, err
 	}
 	return &TokenProtectedService{
-		next:   next,
-		tokens: config.Tokens,
+		next:    next,
+		handler: auth.Wrap(next),
 	}, nil
 }
 
 func init() {
 	common.Services.Register("token-protected", NewTokenProtectedService)
+	common.Services.RegisterConfig("token-protected", TokenProtectedConfig{})
 }
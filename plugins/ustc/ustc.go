@@ -3,7 +3,8 @@ package ustc
 import (
 	"encoding/json"
 	"encoding/xml"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -27,22 +28,31 @@ type UstcIdConfig struct {
 	Timeout     string `json:"timeout"`
 }
 
+// Validate implements the common.Validator interface.
+func (c UstcIdConfig) Validate() error {
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		return &common.ConfigError{Path: "bind-address", Err: errors.New("invalid IP address")}
+	}
+	return common.CheckDuration("timeout", c.Timeout)
+}
+
 type UstcIdService struct {
 	client *http.Client
+	log    *slog.Logger
 }
 
 func (s *UstcIdService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		log.Print("Missing 'id' parameter")
-		w.WriteHeader(http.StatusBadRequest)
+		s.log.DebugContext(r.Context(), "Missing 'id' parameter")
+		common.Error(w, r, http.StatusBadRequest, "missing id parameter")
 		return
 	}
 
-	req, err := http.NewRequest("GET", "https://api.lib.ustc.edu.cn/get_info_from_id.php", nil)
+	req, err := http.NewRequestWithContext(r.Context(), "GET", "https://api.lib.ustc.edu.cn/get_info_from_id.php", nil)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.log.ErrorContext(r.Context(), "Failed to create request", "err", err)
+		common.Error(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -52,23 +62,23 @@ func (s *UstcIdService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	res, err := s.client.Do(req)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.log.ErrorContext(r.Context(), "USTC Library API request failed", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		log.Printf("USTC Library API returned %d", res.StatusCode)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.log.ErrorContext(r.Context(), "USTC Library API returned an error", "status", res.StatusCode)
+		common.Error(w, r, http.StatusBadGateway, "")
 		return
 	}
 
 	var info ReaderInfo
 	err = xml.NewDecoder(res.Body).Decode(&info)
 	if err != nil {
-		log.Printf("XML decode error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.log.ErrorContext(r.Context(), "XML decode error", "err", err)
+		common.BackendError(w, r, err)
 		return
 	}
 
@@ -99,9 +109,10 @@ func NewService(rawConfig json.RawMessage) (common.Service, error) {
 		ExpectContinueTimeout: httpTimeout / 2,
 	}
 	httpClient := &http.Client{Transport: httpTransport, Timeout: httpTimeout}
-	return &UstcIdService{client: httpClient}, nil
+	return &UstcIdService{client: httpClient, log: common.Logger()}, nil
 }
 
 func init() {
 	common.Services.Register("ustc-id", NewService)
+	common.Services.RegisterConfig("ustc-id", UstcIdConfig{})
 }
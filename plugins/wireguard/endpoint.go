@@ -3,8 +3,7 @@ package wireguard
 import (
 	"bufio"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os/exec"
 	"strings"
@@ -16,6 +15,19 @@ type Service struct {
 	PublicKey string `json:"public-key"`
 	Interface string `json:"interface"`
 	UseSudo   bool   `json:"use-sudo"`
+
+	log *slog.Logger
+}
+
+// Validate implements the common.Validator interface.
+func (s Service) Validate() error {
+	if s.Interface == "" {
+		return &common.ConfigError{Path: "interface", Err: common.ErrMissing}
+	}
+	if s.PublicKey == "" {
+		return &common.ConfigError{Path: "public-key", Err: common.ErrMissing}
+	}
+	return nil
 }
 
 func stripPort(s string) string {
@@ -26,16 +38,21 @@ func stripPort(s string) string {
 	return s[:lastIndex]
 }
 
+// Describe implements the common.Describer interface.
+func (s *Service) Describe() string {
+	return "Endpoint of a WireGuard peer"
+}
+
 func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	args := []string{"wg", "show", s.Interface, "endpoints"}
 	if s.UseSudo {
 		args = append([]string{"sudo"}, args...)
 	}
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := exec.CommandContext(req.Context(), args[0], args[1:]...)
 	r, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, fmt.Sprintf("internal server error: %v\n", err), http.StatusInternalServerError)
+		s.log.ErrorContext(req.Context(), "Failed to run wg", "err", err)
+		common.Error(w, req, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -53,7 +70,7 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
-	http.Error(w, "server not found", http.StatusInternalServerError)
+	common.Error(w, req, http.StatusNotFound, "peer not found")
 }
 
 func NewService(config json.RawMessage) (common.Service, error) {
@@ -62,9 +79,11 @@ func NewService(config json.RawMessage) (common.Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.log = common.Logger()
 	return s, nil
 }
 
 func init() {
 	common.Services.Register("wireguard.endpoint", NewService)
+	common.Services.RegisterConfig("wireguard.endpoint", Service{})
 }
@@ -18,13 +18,13 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	if req.Method != http.MethodPost {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		common.Error(w, req, http.StatusMethodNotAllowed, "")
 		return
 	}
 
 	f, err := os.Create(s.File)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		common.Error(w, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer f.Close()
@@ -39,17 +39,17 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		n, err = io.Copy(f, req.Body)
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		common.Error(w, req, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Leftover data from io.CopyN
 	n, err = io.Copy(io.Discard, req.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		common.Error(w, req, http.StatusInternalServerError, err.Error())
 		return
 	} else if n > 0 {
-		http.Error(w, fmt.Sprintf("%d", n), http.StatusRequestEntityTooLarge)
+		common.Error(w, req, http.StatusRequestEntityTooLarge, fmt.Sprintf("%d bytes over the limit", n))
 		return
 	}
 
@@ -58,4 +58,5 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func init() {
 	common.Services.Register("writefile", common.NewService[*Service])
+	common.Services.RegisterConfig("writefile", Service{})
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the time a client may take to send the PROXY
+// protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts connections that start with a PROXY protocol header
+// (version 1 or 2), as sent by HAProxy and similar load balancers, and
+// reports the client address from the header as their remote address.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn reads the PROXY header on first use rather than in Accept, so
+// that a slow client does not hold up accepting other connections.
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY header from r and returns the source address
+// in it, or nil if the header does not carry one (e.g. health checks).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil && !bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errors.New("missing header")
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes including CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("invalid v1 header")
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid v1 header")
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 header: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 header: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	const (
+		cmdLocal = 0x0
+		cmdProxy = 0x1
+		tcp4     = 0x11
+		tcp6     = 0x21
+	)
+	switch header[12] & 0xf {
+	case cmdLocal:
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported command %d", header[12]&0xf)
+	}
+	switch header[13] {
+	case tcp4:
+		if len(body) < 12 {
+			return nil, errors.New("short v2 address")
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[8:10]))), nil
+	case tcp6:
+		if len(body) < 36 {
+			return nil, errors.New("short v2 address")
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[32:34]))), nil
+	}
+	// Other address families are not of interest
+	return nil, nil
+}
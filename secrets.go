@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// resolveSecrets expands ${VAR} references to environment variables in
+// scalar values, and replaces values tagged !file or !credential (or written
+// as {$file: ...} or {$credential: ...}) with the content of that file, where
+// credentials are looked up in systemd's $CREDENTIALS_DIRECTORY. Relative
+// file paths are resolved against dir.
+//
+// Resolved values must never appear in logs or error messages.
+func resolveSecrets(node *yaml.Node, dir string) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := resolveSecrets(n, dir); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if len(node.Content) == 2 && node.Content[1].Kind == yaml.ScalarNode {
+			// The "$" keeps plugin configs that only have a "file" key intact
+			switch key := node.Content[0].Value; key {
+			case "$file", "$credential":
+				value := node.Content[1]
+				value.Tag = "!" + key[1:]
+				*node = *value
+				return resolveSecrets(node, dir)
+			}
+		}
+		// Keys are left alone
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := resolveSecrets(node.Content[i], dir); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		switch node.Tag {
+		case "!file", "!credential":
+			content, err := readSecret(node.Tag, value, dir)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", node.Line, err)
+			}
+			node.Value = content
+			node.Tag = "!!str"
+			node.Style = yaml.DoubleQuotedStyle
+		default:
+			if value == node.Value {
+				break
+			}
+			node.Value = value
+			switch node.Tag {
+			case "!!int", "!!float", "!!bool":
+				// Typed explicitly, like "port: !!int ${PORT}"
+				var v any
+				if err := node.Decode(&v); err != nil {
+					return fmt.Errorf("line %d: value is not a valid %s", node.Line, node.Tag)
+				}
+			default:
+				// Values like "0123" or "on" must not turn into numbers or
+				// booleans
+				node.Tag = "!!str"
+				node.Style = yaml.DoubleQuotedStyle
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces ${VAR} with the value of the environment variable VAR,
+// which must be set. "$${" stands for a literal "${".
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return "", errors.New("unterminated ${ in value")
+		}
+		name := s[i+2 : i+j]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+j+1:]
+	}
+}
+
+func readSecret(tag, name, dir string) (string, error) {
+	path := name
+	if tag == "!credential" {
+		credDir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if credDir == "" {
+			return "", fmt.Errorf("credential %q: $CREDENTIALS_DIRECTORY is not set", name)
+		}
+		path = filepath.Join(credDir, name)
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Only the path is reported, never the content
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return "", fmt.Errorf("read secret %s: %w", path, pathErr.Err)
+		}
+		return "", fmt.Errorf("read secret %s: %w", path, err)
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
@@ -5,23 +5,50 @@ import (
 	"sync"
 )
 
+// trackedHandler counts the requests currently being served by a handler,
+// so that it can be stopped once all of them have finished.
+type trackedHandler struct {
+	http.Handler
+	wg sync.WaitGroup
+}
+
 type ReloadableHandler struct {
 	mu sync.RWMutex
-	h  http.Handler
+	h  *trackedHandler
 }
 
 func (h *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.Get().ServeHTTP(w, r)
+	h.mu.RLock()
+	t := h.h
+	t.wg.Add(1)
+	h.mu.RUnlock()
+	defer t.wg.Done()
+	t.ServeHTTP(w, r)
 }
 
 func (h *ReloadableHandler) Get() http.Handler {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.h
+	if h.h == nil {
+		return nil
+	}
+	return h.h.Handler
 }
 
 func (h *ReloadableHandler) Set(handler http.Handler) {
+	h.Swap(handler)
+}
+
+// Swap installs handler and returns the previous one (nil if there was none),
+// together with a function that blocks until all requests in flight on the
+// previous handler have completed.
+func (h *ReloadableHandler) Swap(handler http.Handler) (http.Handler, func()) {
 	h.mu.Lock()
-	h.h = handler
+	old := h.h
+	h.h = &trackedHandler{Handler: handler}
 	h.mu.Unlock()
+	if old == nil {
+		return nil, func() {}
+	}
+	return old.Handler, old.wg.Wait
 }
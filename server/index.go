@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/iBug/uniAPI/common"
+)
+
+// IndexEntry describes a service in the index of a server.
+type IndexEntry struct {
+	Key         string       `json:"key"`
+	Path        string       `json:"path"`
+	Type        string       `json:"type"`
+	Description string       `json:"description,omitempty"`
+	Host        string       `json:"host,omitempty"`
+	Methods     []string     `json:"methods,omitempty"`
+	Services    []IndexEntry `json:"services,omitempty"`
+}
+
+// indexEntries lists the services of s that requests for host may reach,
+// and those of nested servers, also behind wrappers like auth, with
+// mountPath being where s is mounted.
+func (s *Server) indexEntries(host, mountPath string) []IndexEntry {
+	entries := []IndexEntry{}
+	for _, rt := range s.routes {
+		if !rt.matchHost(host) {
+			continue
+		}
+		var config common.TypeConfig
+		json.Unmarshal(s.configs[rt.key], &config)
+		service := s.services[rt.key]
+		e := IndexEntry{
+			Key:         rt.key,
+			Path:        joinMountPath(mountPath, rt.prefix),
+			Type:        config.Type,
+			Description: common.Describe(service),
+			Host:        rt.host,
+			Methods:     rt.methods,
+		}
+		if nested, ok := asServer(service); ok {
+			e.Services = nested.indexEntries(host, e.Path)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+{{template "list" .Services}}
+</body>
+</html>
+{{define "list"}}<ul>
+{{range .}}<li><a href="{{.Path}}">{{.Path}}</a> ({{.Type}}{{range .Methods}} {{.}}{{end}}){{with .Description}}: {{.}}{{end}}
+{{with .Services}}{{template "list" .}}{{end}}</li>
+{{end}}</ul>{{end}}`))
+
+// serveIndex serves the index of s as JSON, or as HTML to browsers.
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	mountPath := common.MountPath(r.Context())
+	services := s.indexEntries(r.Host, mountPath)
+	w.Header().Set("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		indexTemplate.Execute(w, struct {
+			Path     string
+			Services []IndexEntry
+		}{joinMountPath(mountPath, ""), services})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"services": services})
+}
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/iBug/uniAPI/common"
 )
@@ -14,66 +22,437 @@ import (
 type ServiceSet map[string]json.RawMessage
 
 type ServerConfig struct {
-	Services ServiceSet `json:"services"`
+	Services ServiceSet `json:"services" registry:"service,mount"`
+	// Index lists the services at the root of the server
+	Index bool `json:"index"`
+}
+
+// ServiceError is returned by NewServer when a service fails to be created.
+type ServiceError struct {
+	Key string
+	Err error
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("failed to create service %q: %v", e.Key, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// route is where a service is mounted, and which requests it gets.
+type route struct {
+	key     string
+	prefix  string // "" at the root
+	host    string
+	methods []string
+}
+
+func newRoute(key string, mount common.MountConfig) route {
+	prefix := key
+	if mount.Prefix != nil {
+		prefix = *mount.Prefix
+	}
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		prefix = ""
+	}
+	return route{key: key, prefix: prefix, host: strings.ToLower(mount.Host), methods: mount.Methods}
+}
+
+func (rt *route) matchPath(p string) bool {
+	return rt.prefix == "" || p == rt.prefix || strings.HasPrefix(p, rt.prefix+"/")
+}
+
+func (rt *route) matchHost(host string) bool {
+	if rt.host == "" {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if domain, ok := strings.CutPrefix(rt.host, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == rt.host
+}
+
+func (rt *route) allows(method string) bool {
+	return len(rt.methods) == 0 || slices.Contains(rt.methods, method) ||
+		method == http.MethodHead && slices.Contains(rt.methods, http.MethodGet)
+}
+
+// overlaps reports whether a request could match both rt and other.
+func (rt *route) overlaps(other *route) bool {
+	if rt.prefix != other.prefix || rt.host != other.host {
+		return false
+	}
+	if len(rt.methods) == 0 || len(other.methods) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(rt.methods, other.allows)
+}
+
+// compareRoutes orders routes by how specific they are, so that the first
+// one matching a request is the best. Routes with the same prefix are kept
+// together.
+func compareRoutes(a, b route) int {
+	if c := cmp.Compare(strings.Count(b.prefix, "/"), strings.Count(a.prefix, "/")); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.prefix, b.prefix); c != 0 {
+		return c
+	}
+	// Exact hosts, then wildcards from the longest, then any host
+	hostRank := func(host string) int {
+		switch {
+		case host == "":
+			return 2
+		case strings.HasPrefix(host, "*."):
+			return 1
+		}
+		return 0
+	}
+	if c := cmp.Compare(hostRank(a.host), hostRank(b.host)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(b.host), len(a.host)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+// joinMountPath appends prefix to the mount path of a server.
+func joinMountPath(base, prefix string) string {
+	p := strings.TrimSuffix(base, "/") + prefix
+	if p == "" {
+		return "/"
+	}
+	return p
 }
 
 type Server struct {
 	services map[string]common.Service
+	configs  ServiceSet // without the keys of common.MountConfig
+	routes   []route
+	index    bool
+	log      *slog.Logger
+
+	// reused are the services carried over from the server this one was
+	// updated from, which are already running
+	reused map[string]bool
 }
 
 func NewServer(serviceset ServiceSet) (*Server, error) {
-	s := new(Server)
-	s.services = make(map[string]common.Service)
-	err := s.loadServices(serviceset)
-	if err != nil {
-		return nil, err
-	}
-	return s, nil
+	return (*Server)(nil).Update(serviceset, nil)
 }
 
-func (s *Server) loadServices(serviceset ServiceSet) error {
-	// var typeConfig common.TypeConfig
+// Update constructs a new Server for serviceset, reusing the services of s
+// whose config is unchanged, so that they keep their state and connections.
+// Nested servers are updated recursively. Services whose config stale
+// reports true for are constructed anew even if unchanged, e.g. when a
+// shared instance they refer to was replaced.
+//
+// Reused services are neither started by Start on the new server nor
+// stopped by StopReplaced on s.
+func (s *Server) Update(serviceset ServiceSet, stale func(json.RawMessage) bool) (*Server, error) {
+	next := &Server{
+		services: make(map[string]common.Service),
+		configs:  make(ServiceSet),
+		reused:   make(map[string]bool),
+		log:      common.Logger(),
+	}
 	for key, cfg := range serviceset {
-		service, err := common.Services.NewFromConfig(cfg)
+		key = path.Clean(key)
+		exit := common.EnterPath(common.CurrentPath() + "/" + key)
+		mount, cfg, err := common.SplitMount(cfg)
+		if err == nil {
+			err = mount.Validate()
+		}
+		var service common.Service
+		var reused bool
+		if err == nil {
+			service, reused, err = s.updateService(key, cfg, stale)
+		}
+		exit()
 		if err != nil {
-			return fmt.Errorf("failed to create service %q: %v", key, err)
+			return nil, &ServiceError{Key: key, Err: err}
 		}
-		s.services[path.Clean(key)] = service
+		next.services[key] = service
+		next.configs[key] = cfg
+		next.routes = append(next.routes, newRoute(key, mount))
+		next.reused[key] = reused
 	}
-	return nil
+	slices.SortFunc(next.routes, compareRoutes)
+	for i := range next.routes {
+		for j := i + 1; j < len(next.routes) && next.routes[j].prefix == next.routes[i].prefix; j++ {
+			if a, b := &next.routes[i], &next.routes[j]; a.overlaps(b) {
+				return nil, fmt.Errorf("services %q and %q are both mounted at %q", a.key, b.key, joinMountPath("", a.prefix))
+			}
+		}
+	}
+	return next, nil
 }
 
+func (s *Server) updateService(key string, cfg json.RawMessage, stale func(json.RawMessage) bool) (service common.Service, reused bool, err error) {
+	if s == nil {
+		service, err = common.Services.NewFromConfig(cfg)
+		return service, false, err
+	}
+	old, ok := s.services[key]
+	if !ok {
+		service, err = common.Services.NewFromConfig(cfg)
+		return service, false, err
+	}
+	isStale := stale != nil && stale(cfg)
+	if bytes.Equal(s.configs[key], cfg) && !isStale {
+		return old, true, nil
+	}
+
+	// Keep the unchanged services of a nested server
+	if oldServer, ok := old.(*Server); ok {
+		var config struct {
+			common.TypeConfig
+			common.LogLevelConfig
+			ServerConfig
+		}
+		if err := json.Unmarshal(cfg, &config); err == nil && config.Type == "server" {
+			if err := common.Services.CheckShallow(config.Type, cfg); err != nil {
+				return nil, false, err
+			}
+			level, err := config.Level()
+			if err != nil {
+				return nil, false, err
+			}
+			var oldConfig common.LogLevelConfig
+			json.Unmarshal(s.configs[key], &oldConfig)
+			if oldConfig.LogLevel != config.LogLevel {
+				// Reused services would keep logging at the old level
+				stale = func(json.RawMessage) bool { return true }
+			}
+			exit := common.EnterPlugin(config.Type, level)
+			next, err := oldServer.Update(config.Services, stale)
+			exit()
+			if err != nil {
+				return nil, false, err
+			}
+			next.SetIndex(config.Index)
+			return next, false, nil
+		}
+	}
+	service, err = common.Services.NewFromConfig(cfg)
+	return service, false, err
+}
+
+// SetIndex sets whether s lists its services at its root, where it would
+// respond with 404 otherwise. It must be called before s serves requests.
+func (s *Server) SetIndex(index bool) {
+	s.index = index
+}
+
+// Start starts all services that implement common.Activator, including
+// nested servers, except those reused from a previous server. If any service
+// fails to start, those already started are stopped again and the error is
+// returned.
 func (s *Server) Start() error {
-	for _, service := range s.services {
-		if activator, ok := service.(common.Activator); ok {
-			if err := activator.Start(); err != nil {
-				log.Printf("Failed to start service: %v", err)
+	started := make([]string, 0, len(s.services))
+	for key, service := range s.services {
+		if s.reused[key] {
+			continue
+		}
+		if err := common.Activate(service); err != nil {
+			for _, key := range started {
+				if err := common.Deactivate(s.services[key]); err != nil {
+					s.log.Error("Failed to stop service", "service", key, "err", err)
+				}
 			}
+			return fmt.Errorf("failed to start service %q: %w", key, err)
 		}
+		started = append(started, key)
 	}
 	return nil
 }
 
+// Stop stops all services that implement common.Activator, including nested
+// servers.
 func (s *Server) Stop() error {
-	for _, service := range s.services {
-		if activator, ok := service.(common.Activator); ok {
-			if err := activator.Stop(); err != nil {
-				log.Printf("Failed to stop service: %v", err)
+	var errs []error
+	for key, service := range s.services {
+		if err := common.Deactivate(service); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop service %q: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StopReplaced stops the services of s that next, which was updated from s,
+// does not reuse, and drops the metrics of the paths that next does not
+// serve.
+func (s *Server) StopReplaced(next *Server) error {
+	err := s.stopReplaced(next)
+	live := make(map[string]bool)
+	for _, m := range next.Mounts() {
+		live[m.Path] = true
+	}
+	for _, m := range s.Mounts() {
+		if !live[m.Path] {
+			common.DeleteSeries("service", m.Path)
+		}
+	}
+	return err
+}
+
+func (s *Server) stopReplaced(next *Server) error {
+	var errs []error
+	for key, service := range s.services {
+		if next.reused[key] {
+			continue
+		}
+		var err error
+		nextServer, ok := next.services[key].(*Server)
+		if oldServer, isServer := service.(*Server); ok && isServer {
+			err = oldServer.stopReplaced(nextServer)
+		} else {
+			err = common.Deactivate(service)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop service %q: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// asServer returns the server that service is, or wraps as a
+// common.Wrapper.
+func asServer(service common.Service) (*Server, bool) {
+	for {
+		switch s := service.(type) {
+		case *Server:
+			return s, true
+		case common.Wrapper:
+			service = s.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Mounts returns the services of s and of nested servers, also behind
+// wrappers like auth, sorted by path.
+func (s *Server) Mounts() []common.Mount {
+	var mounts []common.Mount
+	for _, rt := range s.routes {
+		var config common.TypeConfig
+		json.Unmarshal(s.configs[rt.key], &config)
+		mountPath := joinMountPath("", rt.prefix)
+		mounts = append(mounts, common.Mount{Path: mountPath, Type: config.Type, Host: rt.host, Methods: rt.methods})
+		if nested, ok := asServer(s.services[rt.key]); ok {
+			for _, m := range nested.Mounts() {
+				m.Path = joinMountPath(mountPath, strings.TrimSuffix(m.Path, "/"))
+				if m.Host == "" {
+					m.Host = rt.host
+				}
+				if len(m.Methods) == 0 {
+					m.Methods = rt.methods
+				}
+				mounts = append(mounts, m)
 			}
 		}
 	}
-	return nil
+	slices.SortStableFunc(mounts, func(a, b common.Mount) int { return strings.Compare(a.Path, b.Path) })
+	return mounts
+}
+
+var (
+	requestsTotal = common.NewCounterVec("uniapi_http_requests_total",
+		"HTTP requests by the path of the service and status code.", "service", "code")
+	requestDuration = common.NewHistogramVec("uniapi_http_request_duration_seconds",
+		"Time taken to serve HTTP requests by the path of the service.", common.DefBuckets, "service")
+)
+
+// match returns the most specific route for r. If there are routes for the
+// path and host of r but none allows its method, it returns the methods that
+// they allow instead.
+func (s *Server) match(r *http.Request) (*route, []string) {
+	p := path.Clean("/" + r.URL.Path)
+	var found *route
+	var allowed []string
+	for i := range s.routes {
+		rt := &s.routes[i]
+		if found != nil && rt.prefix != found.prefix {
+			break
+		}
+		if !rt.matchPath(p) || !rt.matchHost(r.Host) {
+			continue
+		}
+		if rt.allows(r.Method) {
+			return rt, nil
+		}
+		found = rt
+		allowed = append(allowed, rt.methods...)
+		if slices.Contains(rt.methods, http.MethodGet) {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	slices.Sort(allowed)
+	return nil, slices.Compact(allowed)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Serving %s", r.URL.Path)
-	key := strings.SplitN(path.Clean(r.URL.Path), "/", 3)[1]
-	service, ok := s.services[key]
-	if !ok {
-		http.NotFound(w, r)
+	rt, allowed := s.match(r)
+	if rt == nil {
+		s.log.DebugContext(r.Context(), "No service for request", "host", r.Host, "path", r.URL.Path, "method", r.Method)
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			common.Error(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		if s.index && path.Clean("/"+r.URL.Path) == "/" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			s.serveIndex(w, r)
+			return
+		}
+		common.Error(w, r, http.StatusNotFound, "")
 		return
 	}
-	http.StripPrefix("/"+key, service).ServeHTTP(w, r)
+	service := s.services[rt.key]
+	mountPath := joinMountPath(common.MountPath(r.Context()), rt.prefix)
+	r = r.WithContext(common.WithMountPath(r.Context(), mountPath))
+	common.GetRequestInfo(r.Context()).Service = mountPath
+	if rt.prefix != "" {
+		r = stripPrefix(r, rt.prefix)
+	}
+	if _, ok := service.(*Server); ok {
+		// Counted by the nested server
+		service.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+	rec := &common.ResponseRecorder{ResponseWriter: w}
+	defer func() {
+		requestsTotal.Inc(mountPath, strconv.Itoa(rec.StatusCode()))
+		requestDuration.Observe(time.Since(start).Seconds(), mountPath)
+	}()
+	service.ServeHTTP(rec, r)
+}
+
+// stripPrefix returns a shallow copy of r with prefix removed from its path.
+// Unlike http.StripPrefix, it removes it from the cleaned path that routes
+// are matched against, keeping a trailing slash.
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	p := path.Clean("/" + r.URL.Path)
+	if p != "/" && strings.HasSuffix(r.URL.Path, "/") {
+		p += "/"
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(p, prefix)
+	r2.URL.RawPath = ""
+	return r2
 }
 
 func NewServerFromConfig(rawConfig json.RawMessage) (common.Service, error) {
@@ -81,9 +460,15 @@ func NewServerFromConfig(rawConfig json.RawMessage) (common.Service, error) {
 	if err := json.Unmarshal(rawConfig, &config); err != nil {
 		return nil, err
 	}
-	return NewServer(config.Services)
+	s, err := NewServer(config.Services)
+	if err != nil {
+		return nil, err
+	}
+	s.SetIndex(config.Index)
+	return s, nil
 }
 
 func init() {
 	common.Services.Register("server", NewServerFromConfig)
+	common.Services.RegisterConfig("server", ServerConfig{})
 }
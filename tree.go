@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/iBug/uniAPI/common"
+	"github.com/iBug/uniAPI/server"
+)
+
+// tree is everything constructed from one version of the config: the shared
+// commanders and streamers, and the services that may refer to them.
+type tree struct {
+	*server.Server
+	config     *Config
+	commanders map[string]common.Commander
+	streamers  map[string]common.Streamer
+
+	// reused are the paths of the shared instances carried over from the
+	// previous tree
+	reused map[string]bool
+}
+
+// newShared constructs the shared instances of one kind, reusing those of
+// old whose config is unchanged.
+func newShared[T any](r *common.RegistryT[T], key string, configs, oldConfigs map[string]json.RawMessage, old map[string]T, t *tree) (map[string]T, error) {
+	instances := make(map[string]T, len(configs))
+	for name, b := range configs {
+		if instance, ok := old[name]; ok && bytes.Equal(oldConfigs[name], b) {
+			instances[name] = instance
+			t.reused[key+"."+name] = true
+			continue
+		}
+		exit := common.EnterPath(key + "." + name)
+		instance, err := r.NewFromConfig(b)
+		exit()
+		if err != nil {
+			err = fmt.Errorf("failed to create %s %q: %w", r.Kind(), name, err)
+			return nil, t.config.source.Locate(key+"."+name, err)
+		}
+		instances[name] = instance
+	}
+	return instances, nil
+}
+
+// newTree constructs the tree for config. If old is not nil, its services
+// and shared instances whose config is unchanged are reused.
+func newTree(config *Config, old *tree) (*tree, error) {
+	t := &tree{config: config, reused: make(map[string]bool)}
+	var oldServer *server.Server
+	oldConfig := new(Config)
+	if old != nil {
+		oldServer = old.Server
+		oldConfig = old.config
+	} else {
+		old = new(tree)
+	}
+
+	var err error
+	t.commanders, err = newShared(&common.Commanders, "commanders", config.Commanders, oldConfig.Commanders, old.commanders, t)
+	if err != nil {
+		return nil, err
+	}
+	t.streamers, err = newShared(&common.Streamers, "streamers", config.Streamers, oldConfig.Streamers, old.streamers, t)
+	if err != nil {
+		return nil, err
+	}
+	common.Commanders.Share(t.commanders)
+	common.Streamers.Share(t.streamers)
+
+	// Services referring to a replaced shared instance must be rebuilt
+	replaced := make(map[string]bool)
+	for path := range old.shared() {
+		if !t.reused[path] {
+			_, name, _ := strings.Cut(path, ".")
+			replaced[name] = true
+		}
+	}
+	stale := func(b json.RawMessage) bool {
+		return len(replaced) > 0 && slices.ContainsFunc(refs(b), func(name string) bool { return replaced[name] })
+	}
+
+	t.Server, err = oldServer.Update(config.Services, stale)
+	if err != nil {
+		var serviceErr *server.ServiceError
+		if errors.As(err, &serviceErr) {
+			return nil, config.source.Locate("services."+serviceErr.Key, err)
+		}
+		return nil, err
+	}
+	t.Server.SetIndex(config.Server.Index)
+	return t, nil
+}
+
+// refs returns the names of the shared instances referred to anywhere in b.
+func refs(b json.RawMessage) []string {
+	var v any
+	if json.Unmarshal(b, &v) != nil {
+		return nil
+	}
+	var names []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if name, ok := v["ref"].(string); ok && len(v) == 1 {
+				names = append(names, name)
+				return
+			}
+			for _, v := range v {
+				walk(v)
+			}
+		case []any:
+			for _, v := range v {
+				walk(v)
+			}
+		}
+	}
+	walk(v)
+	return names
+}
+
+// shared returns the shared instances by their config paths.
+func (t *tree) shared() map[string]any {
+	instances := make(map[string]any, len(t.commanders)+len(t.streamers))
+	for name, c := range t.commanders {
+		instances["commanders."+name] = c
+	}
+	for name, s := range t.streamers {
+		instances["streamers."+name] = s
+	}
+	return instances
+}
+
+// Start starts the shared commanders and streamers before the services that
+// use them, skipping those reused from the previous tree. On failure,
+// everything already started is stopped again.
+func (t *tree) Start() error {
+	shared := t.shared()
+	var started []string
+	rollback := func() {
+		for _, path := range started {
+			if err := common.Deactivate(shared[path]); err != nil {
+				slog.Error("Failed to stop", "path", path, "err", err)
+			}
+		}
+	}
+	for path, v := range shared {
+		if t.reused[path] {
+			continue
+		}
+		if err := common.Activate(v); err != nil {
+			rollback()
+			return fmt.Errorf("failed to start %s: %w", path, err)
+		}
+		started = append(started, path)
+	}
+	if err := t.Server.Start(); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+// Stop stops the services before the shared commanders and streamers.
+func (t *tree) Stop() error {
+	errs := []error{t.Server.Stop()}
+	for path, v := range t.shared() {
+		if err := common.Deactivate(v); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopReplaced stops what next, which was constructed from t, does not
+// reuse.
+func (t *tree) stopReplaced(next *tree) error {
+	errs := []error{t.Server.StopReplaced(next.Server)}
+	for path, v := range t.shared() {
+		if next.reused[path] {
+			continue
+		}
+		if err := common.Deactivate(v); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,157 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchDebounce is how long the config files must stay unchanged after a
+// change before a reload is triggered, so that editors saving several files
+// or writing in several steps cause a single reload.
+const watchDebounce = 500 * time.Millisecond
+
+// configWatcher watches the config file, the files it includes and its
+// include directory with inotify.
+//
+// Directories are watched instead of the files themselves, since editors
+// usually save by writing a new file and renaming it over the old one.
+type configWatcher struct {
+	fd int
+
+	mu      sync.Mutex
+	dirs    map[int]string  // watch descriptor to directory
+	files   map[string]bool // watched files
+	incDirs map[string]bool // directories whose *.yml and *.yaml files are watched
+}
+
+func newConfigWatcher() (*configWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	return &configWatcher{fd: fd}, nil
+}
+
+// Watch replaces the set of watched files with those of src.
+func (w *configWatcher) Watch(src *configSource) error {
+	files := make(map[string]bool)
+	incDirs := make(map[string]bool)
+	watchDirs := make(map[string]bool)
+	for _, file := range src.Files {
+		file, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		files[file] = true
+		watchDirs[filepath.Dir(file)] = true
+	}
+	if len(src.Files) > 0 {
+		dir, err := filepath.Abs(includeDir(src.Files[0]))
+		if err != nil {
+			return err
+		}
+		incDirs[dir] = true
+		watchDirs[dir] = true
+	}
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+		syscall.IN_CREATE | syscall.IN_DELETE
+	dirs := make(map[int]string)
+	for dir := range watchDirs {
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, mask)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The include directory is optional
+				continue
+			}
+			return fmt.Errorf("inotify: watch %s: %w", dir, err)
+		}
+		dirs[wd] = dir
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd := range w.dirs {
+		if _, ok := dirs[wd]; !ok {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+	w.dirs, w.files, w.incDirs = dirs, files, incDirs
+	return nil
+}
+
+// relevant reports whether the file name in the directory watched by wd is
+// part of the config.
+func (w *configWatcher) relevant(wd int, name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.dirs[wd]
+	if !ok || name == "" {
+		return false
+	}
+	path := filepath.Join(dir, name)
+	if w.files[path] {
+		return true
+	}
+	if w.incDirs[path] {
+		// The include directory was created, removed or renamed, so the
+		// reload that follows starts or stops watching it
+		return true
+	}
+	if w.incDirs[dir] {
+		switch filepath.Ext(name) {
+		case ".yml", ".yaml":
+			return true
+		}
+	}
+	return false
+}
+
+// Run sends to c once the config files have changed and settled. It never
+// returns unless reading from inotify fails.
+func (w *configWatcher) Run(c chan<- struct{}) error {
+	timer := time.AfterFunc(time.Hour, func() {
+		select {
+		case c <- struct{}{}:
+		default:
+			// A reload is already pending
+		}
+	})
+	timer.Stop()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("inotify: %w", err)
+		}
+		changed := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(event.Len)]
+			off += syscall.SizeofInotifyEvent + int(event.Len)
+
+			// The name is padded with NUL bytes
+			name := string(nameBytes)
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 || w.relevant(int(event.Wd), name) {
+				changed = true
+			}
+		}
+		if changed {
+			timer.Reset(watchDebounce)
+		}
+	}
+}
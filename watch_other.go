@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type configWatcher struct{}
+
+func newConfigWatcher() (*configWatcher, error) {
+	return nil, errors.New("watching config files is only supported on Linux")
+}
+
+func (w *configWatcher) Watch(src *configSource) error { return nil }
+
+func (w *configWatcher) Run(c chan<- struct{}) error { return nil }